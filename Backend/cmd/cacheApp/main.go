@@ -1,27 +1,450 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/wailman24/Caching.git/internal/config"
+	"github.com/wailman24/Caching.git/internal/health"
+	"github.com/wailman24/Caching.git/internal/metrics"
 	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/reconcile"
+	"github.com/wailman24/Caching.git/internal/repositories"
 	"github.com/wailman24/Caching.git/internal/router"
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/writebehind"
 	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/codec"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
 	"github.com/wailman24/Caching.git/pkg/db"
 )
 
 func main() {
 	db.Connect()
 	fmt.Println("connected ... ")
-	err := db.Db.AutoMigrate(&models.User{}, &models.Product{})
+	migrateErr := db.Db.AutoMigrate(&models.User{}, &models.Product{}, &models.CacheStrategy{})
 
-	if err != nil {
-		fmt.Println("Error migrating database:", err)
+	if migrateErr != nil {
+		fmt.Println("Error migrating database:", migrateErr)
 	} else {
 		fmt.Println("Migration completed successfully...")
 	}
 
-	cache.ConnectRedis()
+	cache.Connect()
+	startBreaker()
+	configureKeys()
+	configureCodec()
+	startMetrics()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	startInvalidation(ctx)
+	startLocks()
+	startEvents()
+	startKeyspace(ctx)
+	startKeyMigration(ctx)
+	// The flusher runs even if nothing uses write-behind yet, so the
+	// strategy can be switched on at runtime
+	startWriteBehind()
+	startStrategies(ctx)
+	startHealth(migrateErr)
+	warmUp(ctx)
+
+	srv := &http.Server{Addr: ":8080", Handler: router.MainRoutes()}
+	// After the routes, which register the repositories to reconcile
+	startReconciler(ctx)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	fmt.Println("Shutting down...")
+
+	// Fail /readyz first, and give load balancers SHUTDOWN_DRAIN_DELAY to
+	// notice before the listener closes
+	health.Default.Drain()
+	time.Sleep(config.Duration("SHUTDOWN_DRAIN_DELAY", 0))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SHUTDOWN_TIMEOUT", 15*time.Second))
+	defer cancel()
+
+	// Event streams never end on their own, Shutdown would wait for them
+	events.Default.Close()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("HTTP shutdown:", err)
+	}
+	// Requests are done, nothing new can be queued: flush what is left
+	if writebehind.Default != nil {
+		if err := writebehind.Default.Shutdown(shutdownCtx); err != nil {
+			log.Println("Write-behind flush on shutdown:", err)
+		} else {
+			fmt.Println("Write-behind queue flushed")
+		}
+	}
+}
+
+// startBreaker puts the circuit breaker in front of Redis, unless
+// CACHE_BREAKER=false. While it is open, reads go straight to MySQL instead
+// of each waiting for Redis to time out, and /readyz reports the instance
+// as degraded.
+func startBreaker() {
+	if cache.Rdb == nil || !config.Bool("CACHE_BREAKER", true) {
+		return
+	}
+	cfg := cache.DefaultBreakerConfig()
+	cfg.Window = config.Duration("CACHE_BREAKER_WINDOW", cfg.Window)
+	cfg.MinCalls = config.Int("CACHE_BREAKER_MIN_CALLS", cfg.MinCalls)
+	cfg.ErrorRate = config.Float("CACHE_BREAKER_ERROR_RATE", cfg.ErrorRate)
+	cfg.SlowCall = config.Duration("CACHE_BREAKER_SLOW_CALL", cfg.SlowCall)
+	cfg.SlowRate = config.Float("CACHE_BREAKER_SLOW_RATE", cfg.SlowRate)
+	cfg.OpenFor = config.Duration("CACHE_BREAKER_OPEN_FOR", cfg.OpenFor)
+	cfg.Probes = config.Int("CACHE_BREAKER_PROBES", cfg.Probes)
+
+	b := cache.GuardStore(cfg)
+	b.OnStateChange(func(from, to cache.BreakerState) {
+		fmt.Printf("Cache circuit breaker %s -> %s\n", from, to)
+	})
+}
+
+// startHealth registers the dependencies /readyz checks, each given
+// HEALTH_CHECK_TIMEOUT. MySQL and the schema migration are critical: the
+// instance can't serve without them. Redis isn't, reads go to MySQL while
+// it is down.
+func startHealth(migrateErr error) {
+	health.CheckTimeout = config.Duration("HEALTH_CHECK_TIMEOUT", health.CheckTimeout)
+	r := health.Default
+
+	r.Register(health.Check{
+		Name:     "mysql",
+		Critical: true,
+		Probe: func(ctx context.Context) (string, error) {
+			sqlDB, err := db.Db.DB()
+			if err != nil {
+				return "", err
+			}
+			st := sqlDB.Stats()
+			return fmt.Sprintf("%d open connections, %d in use", st.OpenConnections, st.InUse), sqlDB.PingContext(ctx)
+		},
+	})
+
+	r.Register(health.Check{
+		Name:     "migrations",
+		Critical: true,
+		Probe: func(ctx context.Context) (string, error) {
+			if migrateErr != nil {
+				return "", fmt.Errorf("schema migration failed: %w", migrateErr)
+			}
+			if keys.DefaultMigrator == nil {
+				return "schema migrated", nil
+			}
+			st := keys.DefaultMigrator.Stats()
+			return fmt.Sprintf("schema migrated, %d key migration runs, %d keys copied", st.Runs, st.Copied), nil
+		},
+		// Old keys only cost memory until they expire
+		Degraded: func() (string, bool) {
+			if keys.DefaultMigrator == nil {
+				return "", false
+			}
+			st := keys.DefaultMigrator.Stats()
+			return "key migration failed: " + st.LastError, st.LastError != ""
+		},
+	})
+
+	if cache.Rdb == nil {
+		r.Register(health.Check{
+			Name:  "cache",
+			Probe: func(ctx context.Context) (string, error) { return "in-memory backend", nil },
+		})
+		return
+	}
+
+	r.Register(health.Check{
+		Name: "redis",
+		Probe: func(ctx context.Context) (string, error) {
+			// Straight to Redis, even while the breaker is open
+			return cache.Rdb.Options().Addr, cache.Rdb.Ping(ctx).Err()
+		},
+	})
 
-	http.ListenAndServe(":8080", router.MainRoutes())
+	if cache.StoreBreaker == nil {
+		return
+	}
+	r.Register(health.Check{
+		Name: "circuit_breaker",
+		Probe: func(ctx context.Context) (string, error) {
+			return string(cache.StoreBreaker.State()), nil
+		},
+		Degraded: func() (string, bool) {
+			st := cache.StoreBreaker.Stats()
+			detail := fmt.Sprintf("%s since %s, reading from MySQL: %s", st.State, st.Since.Format(time.RFC3339), st.LastError)
+			return detail, st.State != cache.BreakerClosed
+		},
+	})
+}
+
+// configureKeys sets the namespace of the cache keys: CACHE_KEY_PREFIX, and
+// CACHE_TENANT when several tenants share the Redis server.
+func configureKeys() {
+	keys.Default = keys.New(config.String("CACHE_KEY_PREFIX", keys.DefaultApp), config.String("CACHE_TENANT", ""))
+}
+
+// configureCodec sets how cached values are encoded: CACHE_CODEC (json,
+// msgpack or protobuf), and CACHE_COMPRESSION (none, zstd or snappy) for
+// values of at least CACHE_COMPRESSION_THRESHOLD bytes. Values keep the
+// codec they were written with, so changing these needs no flush.
+func configureCodec() {
+	c, err := codec.Parse(
+		config.String("CACHE_CODEC", codec.FormatJSON),
+		config.String("CACHE_COMPRESSION", codec.CompressionNone),
+		config.Int("CACHE_COMPRESSION_THRESHOLD", 1024),
+	)
+	if err != nil {
+		log.Fatalf("Invalid cache codec: %v", err)
+	}
+	codec.Default = c
+	st := c.Stats()
+	fmt.Printf("Cache codec: %s, compression: %s above %d bytes\n", st.Format, st.Compression, st.Threshold)
+}
+
+// startMetrics instruments MySQL, Redis and the cache reads for /metrics.
+// It runs before anything else uses the Redis client, whose hooks must not
+// change while commands are in flight.
+func startMetrics() {
+	if err := metrics.InstrumentDB(db.Db); err != nil {
+		log.Println("Could not instrument MySQL queries:", err)
+	}
+	if cache.Rdb != nil {
+		metrics.InstrumentRedis(cache.Rdb)
+	}
+	cache.Observer = metrics.Reads
+}
+
+// startInvalidation subscribes this instance to the invalidation bus, which
+// keeps L1 caches coherent across replicas, and to Redis client tracking
+// when the server supports it. Without Redis there is nothing to share.
+func startInvalidation(ctx context.Context) {
+	if cache.Rdb == nil {
+		return
+	}
+	bus := invalidation.New(cache.Rdb, config.String("CACHE_INVALIDATION_CHANNEL", invalidation.DefaultChannel))
+	if cache.StoreBreaker != nil {
+		bus.Guard(cache.StoreBreaker)
+	}
+	bus.Start(ctx)
+	invalidation.Default = bus
+
+	// Tracking also catches writes made outside this application, but it
+	// reports this instance's own writes as well and evicts what they just
+	// put in L1, so it is only worth it when other clients write the keys
+	if !config.Bool("CACHE_CLIENT_TRACKING", false) {
+		return
+	}
+	prefix := repositories.ProductKeys().Prefix()
+	if err := cache.ConnectTracking(ctx, prefix); err != nil {
+		log.Println("Client tracking unavailable, using pub/sub invalidation only:", err)
+		return
+	}
+	fmt.Printf("Client tracking enabled for %s* keys\n", prefix)
+}
+
+// startLocks configures the locks serializing product updates. They live in
+// the cache backend, unless REDLOCK_ADDRS lists independent Redis nodes to
+// take them on a majority of.
+func startLocks() {
+	cfg := lock.DefaultConfig()
+	cfg.TTL = config.Duration("LOCK_TTL", cfg.TTL)
+	cfg.Wait = config.Duration("LOCK_WAIT_TIMEOUT", cfg.Wait)
+
+	addrs := config.String("REDLOCK_ADDRS", "")
+	if addrs == "" {
+		lock.Default = lock.New(cache.Store, cfg)
+		return
+	}
+	nodes := cache.DialNodes(strings.Split(addrs, ","))
+	if len(nodes)%2 == 0 {
+		log.Printf("Redlock over %d nodes: use an odd number, an even one tolerates no more failures than one node less", len(nodes))
+	}
+	lock.Default = lock.NewRedlock(nodes, cfg)
+	fmt.Printf("Redlock enabled on %d nodes\n", len(nodes))
+}
+
+// startEvents creates the bus behind the live event stream. A client that
+// falls CACHE_EVENTS_BUFFER events behind starts losing events.
+func startEvents() {
+	events.Default = events.New(config.Int("CACHE_EVENTS_BUFFER", events.DefaultBuffer))
+}
+
+// startKeyspace listens to the keys Redis evicts, expires or deletes, for
+// the metrics, the event stream and the product index. The notifications
+// are off by default in Redis: they are turned on unless
+// CACHE_KEYSPACE_CONFIGURE=false, e.g. when they are managed with the
+// server config instead.
+func startKeyspace(ctx context.Context) {
+	if cache.Rdb == nil || !config.Bool("CACHE_KEYSPACE_EVENTS", true) {
+		return
+	}
+	if err := cache.ConnectKeyspace(ctx, config.Bool("CACHE_KEYSPACE_CONFIGURE", true)); err != nil {
+		log.Println("Keyspace notifications are off, set notify-keyspace-events to include \"Egxe\" on the server:", err)
+	} else {
+		fmt.Println("Listening to Redis evictions, expirations and deletions")
+	}
+	cache.Keyspace.Subscribe(metrics.ObserveKeyEvents)
+}
+
+// startKeyMigration moves the cache keys of earlier schema versions to the
+// current ones in the background, every CACHE_KEY_MIGRATION_INTERVAL, so
+// the keys still written by the previous release during a rolling deploy
+// are migrated too (0 = only through the admin API).
+func startKeyMigration(ctx context.Context) {
+	if cache.Rdb == nil {
+		return
+	}
+	m := keys.NewMigrator(cache.Rdb, config.Int("CACHE_KEY_MIGRATION_BATCH_SIZE", 500))
+	m.Register(repositories.ProductKeyMigrations()...)
+	keys.DefaultMigrator = m
+
+	if interval := config.Duration("CACHE_KEY_MIGRATION_INTERVAL", time.Minute); interval > 0 {
+		m.Start(ctx, interval)
+	}
+}
+
+// startWriteBehind starts the background flusher used by the write-behind
+// strategy. It needs Redis Streams, so it is not available with the
+// in-memory cache backend.
+func startWriteBehind() {
+	if cache.Rdb == nil {
+		log.Println("Write-behind needs the Redis cache backend, it is disabled")
+		return
+	}
+
+	cfg := writebehind.DefaultConfig()
+	cfg.BatchSize = int64(config.Int("WRITE_BEHIND_BATCH_SIZE", int(cfg.BatchSize)))
+	cfg.FlushInterval = config.Duration("WRITE_BEHIND_FLUSH_INTERVAL", cfg.FlushInterval)
+	cfg.RetryAfter = config.Duration("WRITE_BEHIND_RETRY_AFTER", cfg.RetryAfter)
+	cfg.MaxRetries = int64(config.Int("WRITE_BEHIND_MAX_RETRIES", int(cfg.MaxRetries)))
+
+	q := writebehind.New(cache.Rdb, db.Db, cfg)
+	if cache.StoreBreaker != nil {
+		q.Guard(cache.StoreBreaker)
+	}
+	q.Register(repositories.ProductEntity, repositories.ApplyProductMutation)
+	q.OnDeadLetter(repositories.EvictDeadProduct(cache.Store, invalidation.Default))
+	if err := q.Start(context.Background()); err != nil {
+		// Redis is down: the strategy stays unavailable until a restart
+		log.Println("Could not start write-behind flusher, write-behind is disabled:", err)
+		return
+	}
+	writebehind.Default = q
+	fmt.Println("Write-behind flusher started on stream", cfg.Stream)
+}
+
+// startStrategies loads the caching strategies chosen through the admin API.
+// PRODUCT_WRITE_STRATEGY only sets the write strategy used until one is
+// chosen there.
+func startStrategies(ctx context.Context) {
+	write := strategy.Strategy(config.String("PRODUCT_WRITE_STRATEGY", string(strategy.WriteThrough)))
+	if !strategy.Valid(strategy.OpCreate, write) {
+		log.Printf("Invalid PRODUCT_WRITE_STRATEGY=%q, using write-through", write)
+		write = strategy.WriteThrough
+	}
+
+	reg := strategy.NewRegistry(db.Db)
+	if writebehind.Default == nil {
+		reg.Disable(strategy.WriteBehind, "needs the Redis cache backend")
+		if write == strategy.WriteBehind {
+			log.Println("Write-behind is disabled, falling back to write-through")
+			write = strategy.WriteThrough
+		}
+	}
+	reg.Register(repositories.ProductEntity, repositories.ProductStrategies(write))
+
+	if err := reg.Load(ctx); err != nil {
+		log.Println("Could not load cache strategies, using defaults:", err)
+	}
+	// Pick up changes made through other instances (0 = only at startup)
+	if interval := config.Duration("STRATEGY_RELOAD_INTERVAL", 15*time.Second); interval > 0 {
+		go reg.Watch(ctx, interval)
+	}
+
+	strategy.Default = reg
+}
+
+// startReconciler schedules the cache/DB consistency scans. They are off
+// unless RECONCILE_INTERVAL is set; the admin API can still run one.
+func startReconciler(ctx context.Context) {
+	reconcile.Default.SetBatchSize(config.Int("RECONCILE_BATCH_SIZE", 500))
+	interval := config.Duration("RECONCILE_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+	reconcile.Default.Start(ctx, interval)
+	fmt.Println("Reconciler started, scanning every", interval)
+}
+
+// warmUp preloads the product cache from MySQL, so the first requests after
+// a Redis restart don't all miss. WARMUP_MODE is off, blocking (done
+// before the server starts) or background (the server starts right away
+// but /readyz fails until the warm-up is over).
+func warmUp(ctx context.Context) {
+	mode := config.String("WARMUP_MODE", "off")
+	switch mode {
+	case "off":
+		return
+	case "blocking", "background":
+	default:
+		log.Printf("Invalid WARMUP_MODE=%q, skipping warm-up", mode)
+		return
+	}
+
+	repo := repositories.NewProductRepositorie(db.Db, cache.Store, repositories.WithTTLs(router.ProductTTLs()))
+	batchSize := config.Int("WARMUP_BATCH_SIZE", 1000)
+
+	health.Default.Block("warmup", "starting")
+	run := func() {
+		// A failed warm-up leaves a cold cache, which still works
+		defer health.Default.Unblock("warmup")
+
+		start := time.Now()
+		total, err := repo.CountProducts(ctx)
+		if err != nil {
+			log.Println("Warm-up failed:", err)
+			return
+		}
+		fmt.Printf("Warm-up: loading %d products in batches of %d\n", total, batchSize)
+
+		lastLog := start
+		loaded, err := repo.WarmUp(ctx, batchSize, func(loaded int) {
+			status := fmt.Sprintf("%d/%d products", loaded, total)
+			health.Default.Block("warmup", status)
+			if time.Since(lastLog) >= time.Second {
+				lastLog = time.Now()
+				fmt.Println("Warm-up:", status)
+			}
+		})
+		if err != nil {
+			log.Printf("Warm-up failed after %d products: %v", loaded, err)
+			return
+		}
+		fmt.Printf("Warm-up done: %d products in %s\n", loaded, time.Since(start).Round(time.Millisecond))
+	}
+
+	if mode == "blocking" {
+		run()
+	} else {
+		go run()
+	}
 }
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/internal/utils"
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 1000
+)
+
+type CacheService interface {
+	Stats(ctx context.Context) (repositories.CacheOverview, error)
+	Keys(ctx context.Context, pattern string, limit int) ([]cache.KeyInfo, error)
+}
+
+type CacheHandler struct {
+	serv CacheService
+}
+
+func NewCacheHandler(serv CacheService) *CacheHandler {
+	return &CacheHandler{serv: serv}
+}
+
+// GetStats returns the hits, misses and evictions, the key count and the
+// memory used by the cache, for the dashboard.
+func (ch *CacheHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := ch.serv.Stats(r.Context())
+	switch {
+	case errors.Is(err, repositories.ErrNotInspectable):
+		utils.Error(w, http.StatusNotImplemented, err)
+		return
+	case errors.Is(err, cache.ErrCircuitOpen):
+		utils.Error(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, stats)
+}
+
+// GetKeys lists the cached keys with their type, TTL and size, biggest
+// first, e.g. GET /keys?match=product:*&limit=50.
+func (ch *CacheHandler) GetKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pattern := r.URL.Query().Get("match")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		utils.Error(w, http.StatusBadRequest, fmt.Errorf("invalid match pattern: %w", err))
+		return
+	}
+
+	limit := defaultKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxKeysLimit {
+			utils.Error(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxKeysLimit))
+			return
+		}
+		limit = n
+	}
+
+	keys, err := ch.serv.Keys(r.Context(), pattern, limit)
+	switch {
+	case errors.Is(err, repositories.ErrNotInspectable):
+		utils.Error(w, http.StatusNotImplemented, err)
+		return
+	case errors.Is(err, cache.ErrCircuitOpen):
+		utils.Error(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, keys)
+}
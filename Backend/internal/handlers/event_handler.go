@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wailman24/Caching.git/internal/utils"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// heartbeatInterval keeps idle streams from being closed by proxies.
+	heartbeatInterval = 15 * time.Second
+	// streamWriteTimeout is how long a client may stall a write before it
+	// is disconnected. Until then, events it can't take are dropped.
+	streamWriteTimeout = 10 * time.Second
+)
+
+type EventSource interface {
+	Subscribe(f events.Filter) *events.Subscription
+}
+
+type EventHandler struct {
+	bus EventSource
+}
+
+func NewEventHandler(bus EventSource) *EventHandler {
+	return &EventHandler{bus: bus}
+}
+
+// droppedNotice tells a client how many events it missed because it was
+// too slow.
+type droppedNotice struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// Stream sends the cache events as Server-Sent Events, e.g.
+// GET /events?types=hit,miss&entity=product&prefix=product:1. Each event
+// is a JSON "message"; a "dropped" event reports events that were lost
+// because the client fell behind.
+func (eh *EventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	sub := eh.bus.Subscribe(filter)
+	defer sub.Close()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	write := func(format string, args ...any) bool {
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": ping\n\n") {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Shutting down
+				return
+			}
+			if n := sub.Dropped(); n > 0 {
+				data, _ := json.Marshal(droppedNotice{Type: "dropped", Count: n})
+				if !write("event: dropped\ndata: %s\n\n", data) {
+					return
+				}
+			}
+			data, _ := json.Marshal(e)
+			if !write("id: %d\ndata: %s\n\n", e.ID, data) {
+				return
+			}
+		}
+	}
+}
+
+// StreamWS sends the same events as Stream over a WebSocket, one JSON
+// message per event. Lost events are reported as {"type":"dropped"}.
+func (eh *EventHandler) StreamWS(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The zero Server doesn't check Origin, as for the rest of the API
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		sub := eh.bus.Subscribe(filter)
+		defer sub.Close()
+
+		// The client only ever closes the socket
+		closed := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(io.Discard, ws)
+			close(closed)
+		}()
+
+		send := func(v any) bool {
+			_ = ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			return websocket.JSON.Send(ws, v) == nil
+		}
+
+		for {
+			select {
+			case <-closed:
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				if n := sub.Dropped(); n > 0 && !send(droppedNotice{Type: "dropped", Count: n}) {
+					return
+				}
+				if !send(e) {
+					return
+				}
+			}
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// parseEventFilter reads ?types=, ?entity= and ?prefix=.
+func parseEventFilter(r *http.Request) (events.Filter, error) {
+	q := r.URL.Query()
+	filter := events.Filter{
+		Entity:    q.Get("entity"),
+		KeyPrefix: q.Get("prefix"),
+	}
+	if v := q.Get("types"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			typ := events.Type(strings.TrimSpace(name))
+			if !slices.Contains(events.Types, typ) {
+				return filter, fmt.Errorf("unknown event type %q", typ)
+			}
+			filter.Types = append(filter.Types, typ)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/wailman24/Caching.git/internal/health"
+	"github.com/wailman24/Caching.git/internal/utils"
+)
+
+type Readiness interface {
+	Check(ctx context.Context) health.Report
+	Uptime() time.Duration
+}
+
+type HealthHandler struct {
+	ready Readiness
+}
+
+func NewHealthHandler(ready Readiness) *HealthHandler {
+	return &HealthHandler{ready: ready}
+}
+
+// Healthz is the liveness probe: it answers 200 as long as the process
+// serves requests. It checks no dependency, a restart wouldn't bring MySQL
+// or Redis back.
+func (hh *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	utils.JSON(w, http.StatusOK, "alive", map[string]string{
+		"status": string(health.StatusUp),
+		"uptime": hh.ready.Uptime().Round(time.Second).String(),
+	})
+}
+
+// Readyz is the readiness probe. It checks the dependencies and answers 503
+// while a critical one is down, something still blocks readiness (e.g. the
+// cache warm-up) or the instance is shutting down, and 200 otherwise. A
+// degraded instance (e.g. Redis is down and reads go to MySQL) still takes
+// traffic. The body has the result and latency of every check.
+func (hh *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := hh.ready.Check(r.Context())
+	switch {
+	case report.ShuttingDown:
+		utils.JSON(w, http.StatusServiceUnavailable, "shutting down", report)
+	case !report.Ready:
+		utils.JSON(w, http.StatusServiceUnavailable, "not ready", report)
+	case report.Status == health.StatusDegraded:
+		utils.JSON(w, http.StatusOK, "degraded", report)
+	default:
+		utils.JSON(w, http.StatusOK, "ready", report)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/utils"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+)
+
+type KeyMigrator interface {
+	Run(ctx context.Context, dryRun bool) ([]keys.Report, error)
+	Stats() keys.MigrationStats
+}
+
+type KeyMigrationHandler struct {
+	m KeyMigrator
+}
+
+func NewKeyMigrationHandler(m KeyMigrator) *KeyMigrationHandler {
+	return &KeyMigrationHandler{m: m}
+}
+
+// GetMigrationStats returns what the background key migration moved so far.
+func (kh *KeyMigrationHandler) GetMigrationStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	utils.Success(w, kh.m.Stats())
+}
+
+// Preview reports what Migrate would move, without changing anything: GET
+// /keys/migrate. ?dry_run=false is refused, migrations run on POST.
+func (kh *KeyMigrationHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if dryRun, err := strconv.ParseBool(v); err != nil || !dryRun {
+			w.Header().Set("Allow", http.MethodPost)
+			utils.Error(w, http.StatusMethodNotAllowed, errors.New("migrations need POST, GET is always a dry run"))
+			return
+		}
+	}
+	kh.run(w, r, true)
+}
+
+// Migrate moves the keys of earlier schema versions now and returns one
+// report per migration: POST /keys/migrate.
+func (kh *KeyMigrationHandler) Migrate(w http.ResponseWriter, r *http.Request) {
+	kh.run(w, r, false)
+}
+
+func (kh *KeyMigrationHandler) run(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reports, err := kh.m.Run(r.Context(), dryRun)
+	switch {
+	case errors.Is(err, keys.ErrNoMigrations):
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, reports)
+}
@@ -3,13 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/go-playground/validator/v10"
 	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/repositories"
 	"github.com/wailman24/Caching.git/internal/utils"
+	"github.com/wailman24/Caching.git/pkg/cache"
 )
 
 type ProductService interface {
@@ -17,14 +21,33 @@ type ProductService interface {
 	CreateProduct(ctx context.Context, product *models.Product) error
 	GetProductByID(ctx context.Context, id uint) (*models.Product, error)
 	UpdateProduct(ctx context.Context, product *models.Product) error
+	DeleteProduct(ctx context.Context, id uint) error
+	GetProductTTL(ctx context.Context, id uint) ([]cache.KeyTTL, error)
+	Stats(ctx context.Context) repositories.EntityCacheStats
 }
 
 type ProductHandler struct {
-	serv ProductService
+	serv           ProductService
+	requireIfMatch bool
 }
 
-func NewProductHandler(serv ProductService) *ProductHandler {
-	return &ProductHandler{serv: serv}
+type ProductHandlerOption func(*ProductHandler)
+
+// WithRequireIfMatch rejects updates that don't say which version they
+// were made from (428 Precondition Required), instead of applying them
+// unconditionally.
+func WithRequireIfMatch(require bool) ProductHandlerOption {
+	return func(ph *ProductHandler) {
+		ph.requireIfMatch = require
+	}
+}
+
+func NewProductHandler(serv ProductService, opts ...ProductHandlerOption) *ProductHandler {
+	ph := &ProductHandler{serv: serv}
+	for _, opt := range opts {
+		opt(ph)
+	}
+	return ph
 }
 
 func (ph *ProductHandler) GetAllProducts(w http.ResponseWriter, r *http.Request) {
@@ -59,11 +82,17 @@ func (ph *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = ph.serv.CreateProduct(ctx, &prod)
+	if errors.Is(err, cache.ErrCircuitOpen) {
+		// A write-behind create needs Redis for its ID and its mutation
+		utils.Error(w, http.StatusServiceUnavailable, err)
+		return
+	}
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	w.Header().Set("ETag", productETag(prod.Version))
 	utils.Success(w, prod)
 
 }
@@ -86,12 +115,42 @@ func (ph *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The version the client read, from If-Match or else the body. 0 means
+	// no precondition: the update overwrites whatever is stored.
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		version, ok := parseIfMatch(ifMatch)
+		if !ok {
+			utils.Error(w, http.StatusPreconditionFailed, repositories.ErrVersionConflict)
+			return
+		}
+		prod.Version = version
+	} else if prod.Version == 0 && ph.requireIfMatch {
+		utils.Error(w, http.StatusPreconditionRequired, errors.New("If-Match header required"))
+		return
+	}
+
 	err = ph.serv.UpdateProduct(ctx, &prod)
-	if err != nil {
+	switch {
+	case errors.Is(err, repositories.ErrVersionConflict):
+		utils.Error(w, http.StatusPreconditionFailed, err)
+		return
+	case errors.Is(err, repositories.ErrProductNotFound):
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, repositories.ErrProductLocked):
+		utils.Error(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, cache.ErrCircuitOpen):
+		// The product lock lives in Redis: writing without it could leave
+		// a stale entry behind once Redis is back
+		utils.Error(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
 		utils.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	w.Header().Set("ETag", productETag(prod.Version))
 	utils.Success(w, prod)
 }
 
@@ -106,10 +165,103 @@ func (ph *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 
 	product, err := ph.serv.GetProductByID(ctx, uint(id))
+	if errors.Is(err, repositories.ErrProductNotFound) {
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	}
 	if err != nil {
 		utils.Error(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	w.Header().Set("ETag", productETag(product.Version))
 	utils.Success(w, product)
 }
+
+func (ph *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idparam := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idparam)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	err = ph.serv.DeleteProduct(ctx, uint(id))
+	switch {
+	case errors.Is(err, repositories.ErrProductNotFound):
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, repositories.ErrProductLocked):
+		utils.Error(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, cache.ErrCircuitOpen):
+		utils.Error(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, map[string]uint{"id": uint(id)})
+}
+
+// GetProductTTL shows the effective TTL of the cache keys behind a product.
+func (ph *ProductHandler) GetProductTTL(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idparam := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idparam)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	ttls, err := ph.serv.GetProductTTL(ctx, uint(id))
+	if err != nil {
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, ttls)
+}
+
+// GetCacheStats returns the read, L1, request coalescing and write-behind
+// counters.
+func (ph *ProductHandler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	utils.Success(w, ph.serv.Stats(ctx))
+}
+
+// productETag is the strong ETag of a product version, e.g. "3".
+func productETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// parseIfMatch returns the version an If-Match header requires, 0 for "*".
+// It returns false if no product version can match: a weak ETag (If-Match
+// compares strongly), a malformed one, or a list of several versions.
+func parseIfMatch(header string) (uint64, bool) {
+	var version uint64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, true
+		}
+		s, err := strconv.Unquote(tag)
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v == 0 {
+			continue
+		}
+		if version != 0 && v != version {
+			return 0, false
+		}
+		version = v
+	}
+	return version, version != 0
+}
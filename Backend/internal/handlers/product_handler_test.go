@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version uint64
+		ok      bool
+	}{
+		{header: `"3"`, version: 3, ok: true},
+		{header: `*`, version: 0, ok: true},
+		{header: ` "3" `, version: 3, ok: true},
+		{header: `"3", "3"`, version: 3, ok: true},
+		// A weak tag never matches, a strong one in the list still can
+		{header: `W/"3", "3"`, version: 3, ok: true},
+		{header: `W/"3"`},
+		{header: `3`},
+		{header: `"3`},
+		{header: `""`},
+		{header: `"abc"`},
+		{header: `"0"`},
+		{header: `"-1"`},
+		{header: `"3", "4"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			version, ok := parseIfMatch(tt.header)
+			if version != tt.version || ok != tt.ok {
+				t.Errorf("parseIfMatch(%s) = %d, %v, want %d, %v", tt.header, version, ok, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+// fakeProducts holds one product at version, and checks versions like the
+// repository does.
+type fakeProducts struct {
+	ProductService
+	version uint64
+	err     error
+	calls   int
+}
+
+func (fp *fakeProducts) UpdateProduct(ctx context.Context, p *models.Product) error {
+	fp.calls++
+	if fp.err != nil {
+		return fp.err
+	}
+	if p.Version != 0 && p.Version != fp.version {
+		return repositories.ErrVersionConflict
+	}
+	fp.version++
+	p.Version = fp.version
+	return nil
+}
+
+func TestUpdateProductPreconditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		require    bool
+		ifMatch    string
+		body       string
+		err        error
+		wantStatus int
+		wantETag   string
+		// the service isn't reached when the precondition is rejected
+		wantCalls int
+	}{
+		{name: "matching If-Match", ifMatch: `"2"`, wantStatus: http.StatusOK, wantETag: `"3"`, wantCalls: 1},
+		{name: "stale If-Match", ifMatch: `"1"`, wantStatus: http.StatusPreconditionFailed, wantCalls: 1},
+		{name: "weak If-Match", ifMatch: `W/"2"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "malformed If-Match", ifMatch: `2`, wantStatus: http.StatusPreconditionFailed},
+		{name: "conflicting If-Match list", ifMatch: `"2", "1"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "If-Match wins over the body", ifMatch: `"2"`, body: `"version":1,`, wantStatus: http.StatusOK, wantETag: `"3"`, wantCalls: 1},
+		{name: "If-Match star", require: true, ifMatch: `*`, wantStatus: http.StatusOK, wantETag: `"3"`, wantCalls: 1},
+		{name: "no precondition", wantStatus: http.StatusOK, wantETag: `"3"`, wantCalls: 1},
+		{name: "no precondition when required", require: true, wantStatus: http.StatusPreconditionRequired},
+		{name: "body version when required", require: true, body: `"version":2,`, wantStatus: http.StatusOK, wantETag: `"3"`, wantCalls: 1},
+		{name: "stale body version", body: `"version":1,`, wantStatus: http.StatusPreconditionFailed, wantCalls: 1},
+		{name: "not found", ifMatch: `"2"`, err: repositories.ErrProductNotFound, wantStatus: http.StatusNotFound, wantCalls: 1},
+		{name: "locked", ifMatch: `"2"`, err: repositories.ErrProductLocked, wantStatus: http.StatusConflict, wantCalls: 1},
+		{name: "cache down", ifMatch: `"2"`, err: cache.ErrCircuitOpen, wantStatus: http.StatusServiceUnavailable, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serv := &fakeProducts{version: 2, err: tt.err}
+			ph := NewProductHandler(serv, WithRequireIfMatch(tt.require))
+
+			body := `{"id":1,` + tt.body + `"name":"keyboard","price":"40"}`
+			req := httptest.NewRequest(http.MethodPut, "/products", strings.NewReader(body))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			ph.UpdateProduct(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", got, tt.wantETag)
+			}
+			if serv.calls != tt.wantCalls {
+				t.Errorf("service called %d times, want %d", serv.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUpdateProductInvalidBody(t *testing.T) {
+	ph := NewProductHandler(&fakeProducts{})
+	for _, body := range []string{`{`, `{"id":1}`} {
+		req := httptest.NewRequest(http.MethodPut, "/products", strings.NewReader(body))
+		req.Header.Set("If-Match", `"1"`)
+		rec := httptest.NewRecorder()
+		ph.UpdateProduct(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/reconcile"
+	"github.com/wailman24/Caching.git/internal/utils"
+)
+
+type Reconciler interface {
+	Run(ctx context.Context, entity string, dryRun bool) (reconcile.Report, error)
+	Stats() map[string]reconcile.Stats
+}
+
+type ReconcileHandler struct {
+	rec Reconciler
+}
+
+func NewReconcileHandler(rec Reconciler) *ReconcileHandler {
+	return &ReconcileHandler{rec: rec}
+}
+
+// GetReconcileStats returns the drift found by the background scans of
+// every entity.
+func (rh *ReconcileHandler) GetReconcileStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	utils.Success(w, rh.rec.Stats())
+}
+
+// Reconcile scans one entity now and returns the report, without changing
+// anything: GET /reconcile/product. Repairs are made by Repair, on POST, so
+// a crawler or a prefetch can't trigger one; ?dry_run=false is refused.
+func (rh *ReconcileHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if dryRun, err := strconv.ParseBool(v); err != nil || !dryRun {
+			w.Header().Set("Allow", http.MethodPost)
+			utils.Error(w, http.StatusMethodNotAllowed, errors.New("repairs need POST, GET is always a dry run"))
+			return
+		}
+	}
+	rh.run(w, r, true)
+}
+
+// Repair scans one entity now, repairs the drift it found and returns the
+// report: POST /reconcile/product.
+func (rh *ReconcileHandler) Repair(w http.ResponseWriter, r *http.Request) {
+	rh.run(w, r, false)
+}
+
+func (rh *ReconcileHandler) run(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	report, err := rh.rec.Run(r.Context(), chi.URLParam(r, "entity"), dryRun)
+	switch {
+	case errors.Is(err, reconcile.ErrUnknownEntity):
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, report)
+}
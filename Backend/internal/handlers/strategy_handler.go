@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-playground/validator/v10"
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/utils"
+)
+
+type StrategyRegistry interface {
+	All() []strategy.Setting
+	Set(ctx context.Context, entity string, op strategy.Operation, s strategy.Strategy) error
+}
+
+type StrategyHandler struct {
+	reg StrategyRegistry
+}
+
+func NewStrategyHandler(reg StrategyRegistry) *StrategyHandler {
+	return &StrategyHandler{reg: reg}
+}
+
+type setStrategyRequest struct {
+	Strategy strategy.Strategy `json:"strategy" validate:"required"`
+}
+
+// GetStrategies lists the caching strategy of every entity operation.
+func (sh *StrategyHandler) GetStrategies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	utils.Success(w, sh.reg.All())
+}
+
+// SetStrategy switches the strategy of one entity operation, e.g.
+// PUT /strategies/product/get {"strategy": "cache-aside"}.
+func (sh *StrategyHandler) SetStrategy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req setStrategyRequest
+	var validate = validator.New()
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = validate.Struct(req)
+	if err != nil {
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	setting := strategy.Setting{
+		Entity:    chi.URLParam(r, "entity"),
+		Operation: strategy.Operation(chi.URLParam(r, "operation")),
+		Strategy:  req.Strategy,
+	}
+	err = sh.reg.Set(ctx, setting.Entity, setting.Operation, setting.Strategy)
+	switch {
+	case errors.Is(err, strategy.ErrUnknownEntity), errors.Is(err, strategy.ErrUnknownOperation):
+		utils.Error(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, strategy.ErrInvalidStrategy), errors.Is(err, strategy.ErrStrategyNotEnabled):
+		utils.Error(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		utils.Error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Success(w, setting)
+}
@@ -0,0 +1,200 @@
+// Package health tracks whether this instance is ready to take traffic: the
+// components still preparing it, the dependencies it checks, and whether
+// it is shutting down.
+package health
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status of a dependency, or of the whole instance.
+type Status string
+
+const (
+	StatusUp Status = "up"
+	// StatusDegraded still takes traffic, slower or with less, e.g. reads
+	// going to MySQL while Redis is down.
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+// Check probes one dependency. A Critical one that fails makes the instance
+// unready; any other only degrades it.
+type Check struct {
+	Name     string
+	Critical bool
+	// Probe returns a short description of the dependency, and an error
+	// if it is unavailable. It is given CheckTimeout.
+	Probe func(ctx context.Context) (detail string, err error)
+	// Degraded, if set, reports a dependency that answers but runs in a
+	// degraded mode, e.g. an open circuit breaker.
+	Degraded func() (detail string, degraded bool)
+}
+
+// Result is the outcome of one Check.
+type Result struct {
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is what /readyz answers.
+type Report struct {
+	Status Status `json:"status"`
+	Ready  bool   `json:"ready"`
+	// ShuttingDown is set once Drain was called.
+	ShuttingDown bool `json:"shutting_down"`
+	// Pending lists the components still blocking readiness, e.g. the
+	// warm-up with its progress.
+	Pending   map[string]string `json:"pending,omitempty"`
+	Checks    map[string]Result `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Uptime    string            `json:"uptime"`
+}
+
+// Readiness holds the components still preparing the instance and the
+// dependency checks. It is ready once no component is left, every
+// critical check passes and it isn't draining.
+type Readiness struct {
+	mu      sync.RWMutex
+	pending map[string]string
+	checks  []Check
+
+	started  time.Time
+	draining atomic.Bool
+}
+
+// CheckTimeout bounds each probe, so a hung dependency is reported as down
+// instead of hanging the probe of the orchestrator.
+var CheckTimeout = 2 * time.Second
+
+// Default is the readiness reported by /readyz.
+var Default = NewReadiness()
+
+func NewReadiness() *Readiness {
+	return &Readiness{pending: make(map[string]string), started: time.Now()}
+}
+
+// Block marks the instance not ready until name is unblocked. Calling it
+// again updates status, e.g. to report progress.
+func (r *Readiness) Block(name, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[name] = status
+}
+
+func (r *Readiness) Unblock(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, name)
+}
+
+// Pending returns the status of every component still blocking readiness.
+func (r *Readiness) Pending() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return maps.Clone(r.pending)
+}
+
+// Register adds a dependency check, replacing the one with the same name.
+func (r *Readiness) Register(c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.checks {
+		if r.checks[i].Name == c.Name {
+			r.checks[i] = c
+			return
+		}
+	}
+	r.checks = append(r.checks, c)
+}
+
+// Drain makes the instance unready for good, at the start of a graceful
+// shutdown, so load balancers stop sending it new requests.
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Uptime is how long the instance has been running.
+func (r *Readiness) Uptime() time.Duration {
+	return time.Since(r.started)
+}
+
+// Check runs every dependency check, concurrently, and reports whether the
+// instance is ready.
+func (r *Readiness) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := append([]Check(nil), r.checks...)
+	pending := maps.Clone(r.pending)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{
+		Status:       StatusUp,
+		ShuttingDown: r.draining.Load(),
+		Checks:       make(map[string]Result, len(checks)),
+		CheckedAt:    time.Now(),
+		Uptime:       r.Uptime().Round(time.Second).String(),
+	}
+	if len(pending) > 0 {
+		report.Pending = pending
+	}
+	for i, c := range checks {
+		res := results[i]
+		report.Checks[c.Name] = res
+		switch {
+		case res.Status == StatusUp:
+		case c.Critical && res.Status == StatusDown:
+			report.Status = StatusDown
+		case report.Status == StatusUp:
+			report.Status = StatusDegraded
+		}
+	}
+	if report.ShuttingDown || len(pending) > 0 {
+		report.Status = StatusDown
+	}
+	report.Ready = report.Status != StatusDown
+	return report
+}
+
+func run(ctx context.Context, c Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := c.Probe(ctx)
+	res := Result{
+		Status:    StatusUp,
+		Critical:  c.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+		return res
+	}
+	if c.Degraded != nil {
+		if detail, degraded := c.Degraded(); degraded {
+			res.Status = StatusDegraded
+			res.Detail = detail
+		}
+	}
+	return res
+}
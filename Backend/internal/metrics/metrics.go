@@ -0,0 +1,280 @@
+// Package metrics exports the cache, Redis, MySQL and HTTP behaviour of
+// the application in the Prometheus format, served on /metrics.
+//
+// Everything is registered on the default Prometheus registry, which also
+// carries the Go runtime and process metrics.
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wailman24/Caching.git/internal/reconcile"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/codec"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
+)
+
+var opLabels = []string{"entity", "operation", "strategy"}
+
+var (
+	cacheReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_reads_total",
+		Help: "Reads by the tier that served them: l1 and l2 are hits, db is a miss.",
+	}, append(opLabels, "tier"))
+	cacheStaleReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_stale_reads_total",
+		Help: "Reads answered with an expired entry because reloading it failed.",
+	}, opLabels)
+	cacheCoalescedReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_coalesced_reads_total",
+		Help: "Reads that waited on another request's load instead of running one.",
+	}, opLabels)
+)
+
+// Reads counts the reads recorded through pkg/cache, see cache.Observer.
+var Reads cache.ReadObserver = readObserver{}
+
+type readObserver struct{}
+
+func (readObserver) ObserveTier(op cache.Op, tier cache.Tier) {
+	cacheReads.WithLabelValues(op.Entity, op.Operation, op.Strategy, string(tier)).Inc()
+}
+
+func (readObserver) ObserveStale(op cache.Op) {
+	cacheStaleReads.WithLabelValues(op.Entity, op.Operation, op.Strategy).Inc()
+}
+
+func (readObserver) ObserveCoalesced(op cache.Op) {
+	cacheCoalescedReads.WithLabelValues(op.Entity, op.Operation, op.Strategy).Inc()
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// register adds c to the default registry. A collector registered twice
+// is only logged, metrics must never stop the application.
+func register(c prometheus.Collector) {
+	if err := prometheus.Register(c); err != nil {
+		log.Println("Could not register metrics:", err)
+	}
+}
+
+// RegisterL1 exports the counters of the in-process cache called name.
+func RegisterL1(name string, stats func() local.Stats) {
+	labels := prometheus.Labels{"cache": name}
+	counter := func(metric, help string, value func(local.Stats) int64) {
+		register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        metric,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return float64(value(stats())) }))
+	}
+	gauge := func(metric, help string, value func(local.Stats) int64) {
+		register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        metric,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return float64(value(stats())) }))
+	}
+
+	counter("l1_cache_hits_total", "L1 cache hits.", func(s local.Stats) int64 { return s.Hits })
+	counter("l1_cache_misses_total", "L1 cache misses.", func(s local.Stats) int64 { return s.Misses })
+	counter("l1_cache_evictions_total", "L1 entries evicted to stay within the memory budget.", func(s local.Stats) int64 { return s.Evictions })
+	counter("l1_cache_expired_total", "L1 entries dropped after their TTL.", func(s local.Stats) int64 { return s.Expired })
+	counter("l1_cache_rejected_total", "L1 inserts refused by admission or size.", func(s local.Stats) int64 { return s.Rejected })
+	gauge("l1_cache_entries", "Entries in the L1 cache.", func(s local.Stats) int64 { return int64(s.Entries) })
+	gauge("l1_cache_bytes", "Estimated bytes used by the L1 cache.", func(s local.Stats) int64 { return s.Bytes })
+}
+
+// lockCollector exports the counters of lock.Default, read at scrape time
+// since it is set after the metrics are.
+type lockCollector struct{}
+
+var (
+	lockAcquiredDesc  = prometheus.NewDesc("cache_lock_acquired_total", "Locks acquired.", nil, nil)
+	lockContendedDesc = prometheus.NewDesc("cache_lock_contended_total", "Lock acquisitions that had to wait for another holder.", nil, nil)
+	lockTimeoutsDesc  = prometheus.NewDesc("cache_lock_timeouts_total", "Lock acquisitions that gave up.", nil, nil)
+	lockRenewalsDesc  = prometheus.NewDesc("cache_lock_renewals_total", "Lock TTL extensions by the watchdog.", nil, nil)
+	lockLostDesc      = prometheus.NewDesc("cache_lock_lost_total", "Locks that expired while held.", nil, nil)
+	lockHeldDesc      = prometheus.NewDesc("cache_locks_held", "Locks currently held by this instance.", nil, nil)
+)
+
+func (lockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lockAcquiredDesc
+	ch <- lockContendedDesc
+	ch <- lockTimeoutsDesc
+	ch <- lockRenewalsDesc
+	ch <- lockLostDesc
+	ch <- lockHeldDesc
+}
+
+func (lockCollector) Collect(ch chan<- prometheus.Metric) {
+	if lock.Default == nil {
+		return
+	}
+	st := lock.Default.Stats()
+	ch <- prometheus.MustNewConstMetric(lockAcquiredDesc, prometheus.CounterValue, float64(st.Acquired))
+	ch <- prometheus.MustNewConstMetric(lockContendedDesc, prometheus.CounterValue, float64(st.Contended))
+	ch <- prometheus.MustNewConstMetric(lockTimeoutsDesc, prometheus.CounterValue, float64(st.Timeouts))
+	ch <- prometheus.MustNewConstMetric(lockRenewalsDesc, prometheus.CounterValue, float64(st.Renewals))
+	ch <- prometheus.MustNewConstMetric(lockLostDesc, prometheus.CounterValue, float64(st.Lost))
+	ch <- prometheus.MustNewConstMetric(lockHeldDesc, prometheus.GaugeValue, float64(st.Held))
+}
+
+// reconcileCollector exports the drift found by the background scans of
+// reconcile.Default.
+type reconcileCollector struct{}
+
+var (
+	reconcileDriftDesc    = prometheus.NewDesc("cache_reconcile_drift_total", "Cache entries found to differ from the database.", []string{"entity", "kind"}, nil)
+	reconcileRepairedDesc = prometheus.NewDesc("cache_reconcile_repaired_total", "Drifted cache entries repaired.", []string{"entity"}, nil)
+)
+
+func (reconcileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- reconcileDriftDesc
+	ch <- reconcileRepairedDesc
+}
+
+func (reconcileCollector) Collect(ch chan<- prometheus.Metric) {
+	for entity, st := range reconcile.Default.Stats() {
+		for kind, n := range st.Drift {
+			ch <- prometheus.MustNewConstMetric(reconcileDriftDesc, prometheus.CounterValue, float64(n), entity, kind)
+		}
+		ch <- prometheus.MustNewConstMetric(reconcileRepairedDesc, prometheus.CounterValue, float64(st.Repaired), entity)
+	}
+}
+
+// eventsCollector exports the counters of the live event stream.
+type eventsCollector struct{}
+
+var (
+	eventsPublishedDesc   = prometheus.NewDesc("cache_events_published_total", "Events published on the live event stream.", nil, nil)
+	eventsDroppedDesc     = prometheus.NewDesc("cache_events_dropped_total", "Events lost by stream clients that fell behind.", nil, nil)
+	eventsSubscribersDesc = prometheus.NewDesc("cache_events_subscribers", "Clients connected to the live event stream.", nil, nil)
+)
+
+func (eventsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventsPublishedDesc
+	ch <- eventsDroppedDesc
+	ch <- eventsSubscribersDesc
+}
+
+func (eventsCollector) Collect(ch chan<- prometheus.Metric) {
+	st := events.Default.Stats()
+	ch <- prometheus.MustNewConstMetric(eventsPublishedDesc, prometheus.CounterValue, float64(st.Published))
+	ch <- prometheus.MustNewConstMetric(eventsDroppedDesc, prometheus.CounterValue, float64(st.Dropped))
+	ch <- prometheus.MustNewConstMetric(eventsSubscribersDesc, prometheus.GaugeValue, float64(st.Subscribers))
+}
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by chi route pattern and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by chi route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// ObserveHTTP records a served request. route is the chi route pattern,
+// e.g. /api/products/getbyid/{id}, so IDs don't become label values.
+func ObserveHTTP(method, route string, status int, d time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// keyMigrationCollector exports what keys.DefaultMigrator moved.
+type keyMigrationCollector struct{}
+
+var keyMigrationDesc = prometheus.NewDesc("cache_key_migration_keys_total", "Keys of earlier schema versions migrated, by result (copied, skipped when already migrated, dropped).", []string{"result"}, nil)
+
+func (keyMigrationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keyMigrationDesc
+}
+
+func (keyMigrationCollector) Collect(ch chan<- prometheus.Metric) {
+	if keys.DefaultMigrator == nil {
+		return
+	}
+	st := keys.DefaultMigrator.Stats()
+	ch <- prometheus.MustNewConstMetric(keyMigrationDesc, prometheus.CounterValue, float64(st.Copied), "copied")
+	ch <- prometheus.MustNewConstMetric(keyMigrationDesc, prometheus.CounterValue, float64(st.Skipped), "skipped")
+	ch <- prometheus.MustNewConstMetric(keyMigrationDesc, prometheus.CounterValue, float64(st.Dropped), "dropped")
+}
+
+// codecCollector exports what codec.Default encoded and what compression
+// saved.
+type codecCollector struct{}
+
+var (
+	codecValuesDesc = prometheus.NewDesc("cache_codec_values_total", "Cached values encoded, by format and whether they were compressed.", []string{"format", "compression"}, nil)
+	codecBytesDesc  = prometheus.NewDesc("cache_codec_bytes_total", "Bytes of the encoded values before (raw) and after (stored) compression.", []string{"stage"}, nil)
+)
+
+func (codecCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- codecValuesDesc
+	ch <- codecBytesDesc
+}
+
+func (codecCollector) Collect(ch chan<- prometheus.Metric) {
+	st := codec.Default.Stats()
+	ch <- prometheus.MustNewConstMetric(codecValuesDesc, prometheus.CounterValue, float64(st.Encoded-st.Compressed), st.Format, codec.None.Name())
+	if st.Compression != codec.None.Name() {
+		ch <- prometheus.MustNewConstMetric(codecValuesDesc, prometheus.CounterValue, float64(st.Compressed), st.Format, st.Compression)
+	}
+	ch <- prometheus.MustNewConstMetric(codecBytesDesc, prometheus.CounterValue, float64(st.BytesIn), "raw")
+	ch <- prometheus.MustNewConstMetric(codecBytesDesc, prometheus.CounterValue, float64(st.BytesOut), "stored")
+}
+
+// breakerCollector exports the state of cache.StoreBreaker.
+type breakerCollector struct{}
+
+var (
+	breakerStateDesc    = prometheus.NewDesc("cache_circuit_state", "1 for the current state of the cache circuit breaker (closed, open, half-open), 0 for the others.", []string{"state"}, nil)
+	breakerOpenedDesc   = prometheus.NewDesc("cache_circuit_opened_total", "Times the cache circuit breaker opened.", nil, nil)
+	breakerRejectedDesc = prometheus.NewDesc("cache_circuit_rejected_total", "Cache calls rejected by the open circuit breaker, served by MySQL instead.", nil, nil)
+)
+
+func (breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+	ch <- breakerOpenedDesc
+	ch <- breakerRejectedDesc
+}
+
+func (breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	if cache.StoreBreaker == nil {
+		return
+	}
+	st := cache.StoreBreaker.Stats()
+	for _, state := range []cache.BreakerState{cache.BreakerClosed, cache.BreakerOpen, cache.BreakerHalfOpen} {
+		v := 0.0
+		if st.State == state {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, v, string(state))
+	}
+	ch <- prometheus.MustNewConstMetric(breakerOpenedDesc, prometheus.CounterValue, float64(st.Opened))
+	ch <- prometheus.MustNewConstMetric(breakerRejectedDesc, prometheus.CounterValue, float64(st.Rejected))
+}
+
+func init() {
+	register(lockCollector{})
+	register(reconcileCollector{})
+	register(eventsCollector{})
+	register(keyMigrationCollector{})
+	register(codecCollector{})
+	register(breakerCollector{})
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+var (
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Redis round trips by command; pipelines count as one.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_command_errors_total",
+		Help: "Failed Redis commands. A nil reply, or NOSCRIPT before a script is loaded, is not a failure.",
+	}, []string{"command"})
+	keyspaceEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_keyspace_events_total",
+		Help: "Keys Redis reported as evicted, expired or deleted, by key family (the prefix before the first colon).",
+	}, []string{"event", "family"})
+)
+
+// ObserveKeyEvents counts key events, as a cache.KeyEventHandler.
+func ObserveKeyEvents(_ context.Context, evs []cache.KeyEvent) {
+	for _, ev := range evs {
+		family, _, ok := strings.Cut(ev.Key, ":")
+		if !ok {
+			// Keys outside our families could be anything, keep the
+			// label set bounded
+			family = "other"
+		}
+		keyspaceEvents.WithLabelValues(ev.Kind, family).Inc()
+	}
+}
+
+// InstrumentRedis times every command sent through rdb and exports its
+// connection pool and the server's eviction and expiry counters.
+func InstrumentRedis(rdb *redis.Client) {
+	rdb.AddHook(redisHook{})
+	register(&redisCollector{rdb: rdb})
+}
+
+type redisHook struct{}
+
+func (redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeRedis(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeRedis("pipeline", start, err)
+		return err
+	}
+}
+
+func observeRedis(command string, start time.Time, err error) {
+	redisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, redis.Nil) && !redis.HasErrorPrefix(err, "NOSCRIPT") {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// redisCollector reads the pool stats and INFO stats at scrape time.
+type redisCollector struct {
+	rdb *redis.Client
+}
+
+var (
+	redisPoolHitsDesc     = prometheus.NewDesc("redis_pool_hits_total", "Times a free connection was found in the pool.", nil, nil)
+	redisPoolMissesDesc   = prometheus.NewDesc("redis_pool_misses_total", "Times a new connection had to be opened.", nil, nil)
+	redisPoolTimeoutsDesc = prometheus.NewDesc("redis_pool_timeouts_total", "Times waiting for a connection timed out.", nil, nil)
+	redisPoolTotalDesc    = prometheus.NewDesc("redis_pool_connections", "Connections in the pool.", nil, nil)
+	redisPoolIdleDesc     = prometheus.NewDesc("redis_pool_idle_connections", "Idle connections in the pool.", nil, nil)
+	redisPoolStaleDesc    = prometheus.NewDesc("redis_pool_stale_connections_total", "Stale connections removed from the pool.", nil, nil)
+	redisEvictedDesc      = prometheus.NewDesc("redis_evicted_keys_total", "Keys the server evicted because of maxmemory.", nil, nil)
+	redisExpiredDesc      = prometheus.NewDesc("redis_expired_keys_total", "Keys the server expired.", nil, nil)
+)
+
+func (c *redisCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolHitsDesc
+	ch <- redisPoolMissesDesc
+	ch <- redisPoolTimeoutsDesc
+	ch <- redisPoolTotalDesc
+	ch <- redisPoolIdleDesc
+	ch <- redisPoolStaleDesc
+	ch <- redisEvictedDesc
+	ch <- redisExpiredDesc
+}
+
+func (c *redisCollector) Collect(ch chan<- prometheus.Metric) {
+	ps := c.rdb.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisPoolHitsDesc, prometheus.CounterValue, float64(ps.Hits))
+	ch <- prometheus.MustNewConstMetric(redisPoolMissesDesc, prometheus.CounterValue, float64(ps.Misses))
+	ch <- prometheus.MustNewConstMetric(redisPoolTimeoutsDesc, prometheus.CounterValue, float64(ps.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisPoolTotalDesc, prometheus.GaugeValue, float64(ps.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolIdleDesc, prometheus.GaugeValue, float64(ps.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolStaleDesc, prometheus.CounterValue, float64(ps.StaleConns))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	info, err := c.rdb.Info(ctx, "stats").Result()
+	if err != nil {
+		// Leave the server counters out rather than fail the scrape, the
+		// error is already counted by the hook
+		return
+	}
+	stats := parseInfo(info)
+	if v, ok := stats["evicted_keys"]; ok {
+		ch <- prometheus.MustNewConstMetric(redisEvictedDesc, prometheus.CounterValue, v)
+	}
+	if v, ok := stats["expired_keys"]; ok {
+		ch <- prometheus.MustNewConstMetric(redisExpiredDesc, prometheus.CounterValue, v)
+	}
+}
+
+// parseInfo reads the numeric "field:value" lines of an INFO reply.
+func parseInfo(info string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, line := range strings.Split(info, "\n") {
+		field, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			res[field] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AdminMiddleware only lets through users listed in ADMIN_USER_IDS (comma
+// separated). It must run after AuthMiddleware, which puts the user ID in
+// the request context. With ADMIN_USER_IDS unset nobody is an admin.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value(UserIDKey).(float64)
+		if !ok || !isAdmin(uint(userID)) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code":    http.StatusForbidden,
+				"message": "Admin access required",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAdmin(userID uint) bool {
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+		if err == nil && uint(n) == userID {
+			return true
+		}
+	}
+	return false
+}
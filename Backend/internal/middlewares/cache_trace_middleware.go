@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+// traceWriter adds the X-Cache-Tier header just before the status line is
+// written, once the handler has finished reading from the cache.
+type traceWriter struct {
+	http.ResponseWriter
+	trace       *cache.Trace
+	wroteHeader bool
+}
+
+func (tw *traceWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		if !tw.trace.Empty() {
+			tw.Header().Set("X-Cache-Tier", tw.trace.String())
+		}
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *traceWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// CacheTraceMiddleware reports which cache tier served each read of the
+// request in the X-Cache-Tier response header, e.g. "l1=2, l2=1, db=0".
+func CacheTraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, trace := cache.WithTrace(r.Context())
+		next.ServeHTTP(&traceWriter{ResponseWriter: w, trace: trace}, r.WithContext(ctx))
+	})
+}
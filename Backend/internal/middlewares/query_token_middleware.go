@@ -0,0 +1,16 @@
+package middlewares
+
+import "net/http"
+
+// QueryTokenMiddleware accepts the JWT as ?token= for clients that can't
+// set an Authorization header, such as the browser's EventSource and
+// WebSocket. It must run before AuthMiddleware, and only on the routes
+// that need it: tokens in URLs end up in access logs.
+func QueryTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// CacheStrategy is a caching strategy chosen at runtime for one operation of
+// an entity (e.g. product / get / read-through).
+type CacheStrategy struct {
+	Entity    string    `json:"entity" gorm:"primaryKey;size:50"`
+	Operation string    `json:"operation" gorm:"primaryKey;size:20"`
+	Strategy  string    `json:"strategy" gorm:"size:20;not null"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Product is encoded to protobuf by hand, for the protobuf cache codec, as
+// the message:
+//
+//	message Product {
+//	  uint64 id = 1;
+//	  string name = 2;
+//	  string price = 3;
+//	  uint64 version = 4;
+//	}
+//
+// New fields take new numbers; a number is never reused.
+const (
+	productProtoID      protowire.Number = 1
+	productProtoName    protowire.Number = 2
+	productProtoPrice   protowire.Number = 3
+	productProtoVersion protowire.Number = 4
+)
+
+func (p *Product) MarshalProto() ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, productProtoID, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(p.ID))
+	b = protowire.AppendTag(b, productProtoName, protowire.BytesType)
+	b = protowire.AppendString(b, p.Name)
+	b = protowire.AppendTag(b, productProtoPrice, protowire.BytesType)
+	b = protowire.AppendString(b, p.Price)
+	b = protowire.AppendTag(b, productProtoVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.Version)
+	return b, nil
+}
+
+func (p *Product) UnmarshalProto(b []byte) error {
+	*p = Product{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == productProtoID && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			p.ID = uint(v)
+			b = b[n:]
+		case num == productProtoVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			p.Version = v
+			b = b[n:]
+		case (num == productProtoName || num == productProtoPrice) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if num == productProtoName {
+				p.Name = v
+			} else {
+				p.Price = v
+			}
+			b = b[n:]
+		default:
+			// Unknown fields come from a newer release, skip them
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("product field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Package reconcile periodically compares what the cache holds to the
+// database and repairs the differences (drift). Drift comes from cache
+// writes that failed after the database write succeeded, or from rows
+// edited directly in SQL.
+//
+// Each entity registers a Func that scans its rows; this package schedules
+// the scans and keeps the drift counters.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of drift.
+const (
+	// the cached value differs from the row
+	DriftMismatch = "mismatch"
+	// a "not found" tombstone is cached for a row that exists
+	DriftTombstone = "tombstone"
+	// the row is missing from the entity's ID index
+	DriftMissingFromIndex = "missing_from_index"
+	// the ID index lists a row that doesn't exist
+	DriftOrphanInIndex = "orphan_in_index"
+)
+
+// maxSamples caps the drifts listed in a report.
+const maxSamples = 100
+
+var ErrUnknownEntity = errors.New("unknown entity")
+
+// Options tunes one scan.
+type Options struct {
+	// DryRun only reports drift, nothing is repaired.
+	DryRun    bool
+	BatchSize int
+}
+
+// Drift is one difference between the cache and the database.
+type Drift struct {
+	ID     uint   `json:"id"`
+	Kind   string `json:"kind"`
+	Cached string `json:"cached,omitempty"`
+	Stored string `json:"stored,omitempty"`
+}
+
+// Report is the result of one scan.
+type Report struct {
+	Entity string `json:"entity"`
+	DryRun bool   `json:"dry_run"`
+	// Skipped explains why the scan didn't run, e.g. writes still queued.
+	Skipped  string           `json:"skipped,omitempty"`
+	Checked  int64            `json:"checked"`
+	Drift    map[string]int64 `json:"drift"`
+	Repaired int64            `json:"repaired"`
+	// Samples lists the first drifts found.
+	Samples   []Drift   `json:"samples,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+}
+
+func NewReport(entity string, opts Options) Report {
+	return Report{
+		Entity:    entity,
+		DryRun:    opts.DryRun,
+		Drift:     make(map[string]int64),
+		StartedAt: time.Now(),
+	}
+}
+
+// Add records a drift.
+func (r *Report) Add(d Drift) {
+	r.Drift[d.Kind]++
+	if len(r.Samples) < maxSamples {
+		r.Samples = append(r.Samples, d)
+	}
+}
+
+// Total is the number of drifts found.
+func (r *Report) Total() int64 {
+	var n int64
+	for _, c := range r.Drift {
+		n += c
+	}
+	return n
+}
+
+// Func scans one entity.
+type Func func(ctx context.Context, opts Options) (Report, error)
+
+// Stats accumulates the background scans of one entity.
+type Stats struct {
+	Runs     int64            `json:"runs"`
+	Checked  int64            `json:"checked"`
+	Drift    map[string]int64 `json:"drift"`
+	Repaired int64            `json:"repaired"`
+	// LastRun is the last background scan that ran.
+	LastRun   *Report `json:"last_run,omitempty"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// Reconciler runs the scans of every registered entity.
+type Reconciler struct {
+	batchSize int
+
+	mu       sync.Mutex
+	entities map[string]Func
+	stats    map[string]*Stats
+}
+
+// Default is the reconciler the repositories register with.
+var Default = New(500)
+
+func New(batchSize int) *Reconciler {
+	return &Reconciler{
+		batchSize: batchSize,
+		entities:  make(map[string]Func),
+		stats:     make(map[string]*Stats),
+	}
+}
+
+func (r *Reconciler) Register(entity string, fn Func) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entities[entity] = fn
+	r.stats[entity] = &Stats{Drift: make(map[string]int64)}
+}
+
+// SetBatchSize changes how many rows each scan reads at a time.
+func (r *Reconciler) SetBatchSize(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batchSize = n
+}
+
+// Run scans one entity now. Only repairing runs count towards Stats; a dry
+// run is just a report.
+func (r *Reconciler) Run(ctx context.Context, entity string, dryRun bool) (Report, error) {
+	r.mu.Lock()
+	fn, ok := r.entities[entity]
+	opts := Options{DryRun: dryRun, BatchSize: r.batchSize}
+	r.mu.Unlock()
+	if !ok {
+		return Report{}, fmt.Errorf("%w %q", ErrUnknownEntity, entity)
+	}
+
+	report, err := fn(ctx, opts)
+	report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	if dryRun {
+		return report, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.stats[entity]
+	if err != nil {
+		st.LastError = err.Error()
+		return report, err
+	}
+	if report.Skipped != "" {
+		return report, nil
+	}
+	st.Runs++
+	st.Checked += report.Checked
+	st.Repaired += report.Repaired
+	for kind, n := range report.Drift {
+		st.Drift[kind] += n
+	}
+	st.LastRun = &report
+	st.LastError = ""
+	return report, nil
+}
+
+// Start scans every entity each interval until ctx is done.
+func (r *Reconciler) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, entity := range r.Entities() {
+				report, err := r.Run(ctx, entity, false)
+				switch {
+				case err != nil:
+					log.Printf("Reconcile %s failed: %v", entity, err)
+				case report.Total() > 0:
+					fmt.Printf("Reconcile %s: %d drifts in %d rows, %d repaired\n", entity, report.Total(), report.Checked, report.Repaired)
+				}
+			}
+		}
+	}()
+}
+
+// Entities lists the registered entities, sorted.
+func (r *Reconciler) Entities() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entities := make([]string, 0, len(r.entities))
+	for e := range r.entities {
+		entities = append(entities, e)
+	}
+	sort.Strings(entities)
+	return entities
+}
+
+// Stats returns a copy of the counters of every entity.
+func (r *Reconciler) Stats() map[string]Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make(map[string]Stats, len(r.stats))
+	for entity, st := range r.stats {
+		cp := *st
+		cp.Drift = maps.Clone(st.Drift)
+		res[entity] = cp
+	}
+	return res
+}
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+)
+
+// ErrNotInspectable is returned when the cache backend can't describe its
+// contents, see cache.Inspector.
+var ErrNotInspectable = errors.New("cache backend does not support inspection")
+
+// CacheSource is a repository whose cache activity is part of the cache
+// overview.
+type CacheSource interface {
+	CacheUsage() CacheUsage
+}
+
+// CacheUsage is the cache activity of one repository on this instance.
+type CacheUsage struct {
+	Reads cache.ReadCounts `json:"reads"`
+	L1    *local.Stats     `json:"l1,omitempty"`
+}
+
+// CacheOverview is what the dashboard shows. Hits, misses and the hit rate
+// are the reads of the registered repositories on this instance; the key
+// count, memory and server counters come from the cache backend and are
+// shared by every instance.
+type CacheOverview struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	// Evictions adds up the L1 evictions and the keys the server evicted
+	// because of maxmemory.
+	Evictions int64             `json:"evictions"`
+	Keys      int64             `json:"keys"`
+	Memory    CacheMemory       `json:"memory"`
+	Server    cache.ServerStats `json:"server"`
+	// Entities is keyed by the name the repository was registered under.
+	Entities map[string]CacheUsage `json:"entities"`
+}
+
+// CacheMemory is the memory used by the cache backend. Max is 0, and
+// UsedRatio too, when no maxmemory is set.
+type CacheMemory struct {
+	Used      int64   `json:"used"`
+	Max       int64   `json:"max"`
+	UsedRatio float64 `json:"used_ratio"`
+	Policy    string  `json:"policy,omitempty"`
+}
+
+// CacheRepositorie gathers the cache counters of the repositories and of
+// the backend they share.
+type CacheRepositorie struct {
+	cache cache.Cache
+
+	mu      sync.Mutex
+	sources map[string]CacheSource
+}
+
+func NewCacheRepositorie(c cache.Cache) *CacheRepositorie {
+	return &CacheRepositorie{cache: c, sources: make(map[string]CacheSource)}
+}
+
+// Register includes the cache activity of src, e.g. the product
+// repository, under entity.
+func (cr *CacheRepositorie) Register(entity string, src CacheSource) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.sources[entity] = src
+}
+
+func (cr *CacheRepositorie) Stats(ctx context.Context) (CacheOverview, error) {
+	cr.mu.Lock()
+	sources := maps.Clone(cr.sources)
+	cr.mu.Unlock()
+
+	overview := CacheOverview{Entities: make(map[string]CacheUsage, len(sources))}
+	var reads cache.ReadCounts
+	for entity, src := range sources {
+		usage := src.CacheUsage()
+		overview.Entities[entity] = usage
+		reads = reads.Add(usage.Reads)
+		if usage.L1 != nil {
+			overview.Evictions += usage.L1.Evictions
+		}
+	}
+	overview.Hits = reads.Hits
+	overview.Misses = reads.Misses
+	overview.HitRate = reads.HitRate
+
+	inspector, ok := cr.cache.(cache.Inspector)
+	if !ok {
+		return overview, ErrNotInspectable
+	}
+	server, err := inspector.ServerStats(ctx)
+	if err != nil {
+		return overview, err
+	}
+	overview.Server = server
+	overview.Keys = server.Keys
+	overview.Evictions += server.EvictedKeys
+	overview.Memory = CacheMemory{
+		Used:   server.UsedMemory,
+		Max:    server.MaxMemory,
+		Policy: server.EvictionPolicy,
+	}
+	if server.MaxMemory > 0 {
+		overview.Memory.UsedRatio = float64(server.UsedMemory) / float64(server.MaxMemory)
+	}
+	return overview, nil
+}
+
+// Keys describes the keys matching pattern, biggest first, see
+// cache.Inspector.Keys.
+func (cr *CacheRepositorie) Keys(ctx context.Context, pattern string, limit int) ([]cache.KeyInfo, error) {
+	inspector, ok := cr.cache.(cache.Inspector)
+	if !ok {
+		return nil, ErrNotInspectable
+	}
+	return inspector.Keys(ctx, pattern, limit)
+}
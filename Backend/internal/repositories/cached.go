@@ -0,0 +1,396 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/writebehind"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
+	"github.com/wailman24/Caching.git/pkg/cache/singleflight"
+	"gorm.io/gorm"
+)
+
+// Codec converts an entity to the fields of its cache hash and back, see
+// EncodedCodec for one that fits any model.
+type Codec[T any] interface {
+	Encode(v *T) (map[string]string, error)
+	// Decode returns false for an empty or malformed hash, which is
+	// treated as a cache miss.
+	Decode(h map[string]string) (*T, bool)
+}
+
+// CacheTTLs holds the expiry of each key family of an entity.
+type CacheTTLs struct {
+	Entry cache.TTLPolicy // one hash per row
+	Index cache.TTLPolicy // the set of every ID
+	// Missing is the lifetime of "not found" tombstones. Zero disables
+	// negative caching.
+	Missing cache.TTLPolicy
+}
+
+// ErrLocked is returned when a write waited too long for the lock on a row.
+var ErrLocked = errors.New("record is being updated, try again")
+
+// ErrConflict is returned when an update expected another version than the
+// stored one: the row changed since the client read it.
+var ErrConflict = errors.New("record was modified since it was read")
+
+// CachedConfig describes a GORM model to Cached. Entity, Keys, Codec and ID
+// are required, the rest is optional.
+//
+// Rows are looked up by their id column. A versioned model also needs a
+// version column: updates then bump it, check it against the version the
+// caller read, and cache writes never replace a newer version.
+type CachedConfig[T any] struct {
+	// Entity names the model in strategies, metrics, events and the
+	// write-behind stream, e.g. "product".
+	Entity string
+	Keys   keys.Family
+	Codec  Codec[T]
+	// ID points to the primary key of v.
+	ID func(v *T) *uint
+	// Version points to the version of v, nil for a model without one.
+	Version func(v *T) *uint64
+	// Lookups are the unique columns GetBy can find a row by, with the
+	// value of each in a row.
+	Lookups map[string]func(v *T) string
+
+	// NotFound, Locked and Conflict are returned instead of
+	// gorm.ErrRecordNotFound, ErrLocked and ErrConflict, so callers
+	// keep matching the errors of their entity.
+	NotFound error
+	Locked   error
+	Conflict error
+
+	// TTLs is the expiry of the keys. Without it keys never expire.
+	TTLs CacheTTLs
+	// FillLease makes cache misses coordinate across instances: the first
+	// instance to miss takes a short Redis lease on the key and the others
+	// wait for it to refill the cache instead of all querying the database.
+	FillLease time.Duration
+
+	// L1 puts an in-process cache in front of the shared cache for reads.
+	// Writes update it as well (write-through), so this node never serves
+	// its own stale data.
+	L1 *local.Cache[T]
+	// Bus publishes every write, and evicts from L1 the rows other
+	// instances write.
+	Bus *invalidation.Bus
+	// Tracker evicts from L1 the rows Redis reports as changed through
+	// client tracking, including writes made outside this application.
+	Tracker *cache.Tracker
+	// Keyspace publishes the hashes Redis evicts, expires or deletes, and
+	// removes the IDs of deleted rows from the index.
+	Keyspace *cache.KeyspaceListener
+	// Events receives the hits, misses, writes, invalidations and locks.
+	Events *events.Bus
+	// Locks serializes writes to a row, e.g. a Redlock. By default locks
+	// are kept in the repository's cache.
+	Locks *lock.Locker
+
+	// Queue makes the write-behind strategy available. Without a strategy
+	// registry it is also used for every create and update. The mutations
+	// are applied by the handler registered for Entity on the queue.
+	Queue *writebehind.Queue
+	// IDSeq is the Redis sequence write-behind creates take their ID from.
+	IDSeq string
+	// Strategies picks the strategy of each operation at call time, so it
+	// can be switched without a restart.
+	Strategies *strategy.Registry
+}
+
+// Cached is a repository of T, a GORM model, backed by the cache: reads go
+// through L1, the shared cache and the database with the strategy, TTL and
+// stampede protection configured for the entity, and writes keep every tier
+// and every instance consistent. A new cached entity only needs a
+// CachedConfig and a Codec.
+type Cached[T any] struct {
+	db    *gorm.DB
+	cache cache.Cache
+	cfg   CachedConfig[T]
+
+	// IDs removed from the index by keyspace events, see cached_keyspace.go
+	indexPruned atomic.Int64
+
+	// cache stampede protection, see cached_fill.go
+	flights     singleflight.Group[*T]
+	listFlights singleflight.Group[[]T]
+	leaseWaits  atomic.Int64
+
+	// reads by the tier that served them, see Stats
+	reads cache.Counter
+	// values the codec couldn't encode, see queueEntry
+	uncacheable atomic.Int64
+
+	// refresh-ahead and stale-if-error, see cached_freshness.go
+	refreshing  sync.Map
+	refreshes   atomic.Int64
+	staleServed atomic.Int64
+}
+
+// tombstoneField marks a hash that records a missing row.
+const tombstoneField = "tombstone"
+
+// expiresAtField holds when a hash goes stale (unix milliseconds). With
+// stale-if-error the key itself lives longer than that.
+const expiresAtField = "expires_at"
+
+// versionField holds the version of a versioned model, in its hash and its
+// table. Cache writes never replace a hash holding a newer version.
+const versionField = "version"
+
+func NewCached[T any](db *gorm.DB, c cache.Cache, cfg CachedConfig[T]) *Cached[T] {
+	if cfg.NotFound == nil {
+		cfg.NotFound = gorm.ErrRecordNotFound
+	}
+	if cfg.Locked == nil {
+		cfg.Locked = ErrLocked
+	}
+	if cfg.Conflict == nil {
+		cfg.Conflict = ErrConflict
+	}
+	if cfg.Locks == nil {
+		cfg.Locks = lock.New(c, lock.DefaultConfig())
+	}
+	cr := &Cached[T]{db: db, cache: c, cfg: cfg}
+	if cfg.Keyspace != nil {
+		cfg.Keyspace.Subscribe(cr.handleKeyEvents)
+	}
+	if cfg.L1 != nil {
+		evict := invalidation.Subscriber{
+			Invalidate: cfg.L1.Delete,
+			Flush:      cfg.L1.Purge,
+		}
+		if cfg.Bus != nil {
+			cfg.Bus.Subscribe(evict)
+		}
+		if cfg.Tracker != nil {
+			cfg.Tracker.Subscribe(evict)
+		}
+	}
+	return cr
+}
+
+func (cr *Cached[T]) Entity() string {
+	return cr.cfg.Entity
+}
+
+func (cr *Cached[T]) Keys() keys.Family {
+	return cr.cfg.Keys
+}
+
+func (cr *Cached[T]) id(v *T) uint {
+	return *cr.cfg.ID(v)
+}
+
+// version returns the version of v, 0 for a model without one.
+func (cr *Cached[T]) version(v *T) uint64 {
+	if cr.cfg.Version == nil {
+		return 0
+	}
+	return *cr.cfg.Version(v)
+}
+
+func (cr *Cached[T]) setVersion(v *T, version uint64) {
+	if cr.cfg.Version != nil {
+		*cr.cfg.Version(v) = version
+	}
+}
+
+// hash encodes v, with its version under versionField so HReplaceIfNewer
+// can compare it.
+func (cr *Cached[T]) hash(v *T) (map[string]string, error) {
+	h, err := cr.cfg.Codec.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s %d: %w", cr.cfg.Entity, cr.id(v), err)
+	}
+	if cr.cfg.Version != nil {
+		h[versionField] = strconv.FormatUint(cr.version(v), 10)
+	}
+	return h, nil
+}
+
+// queueEntry adds the writes that cache v to pipe: its hash with a fresh
+// jittered TTL (plus the stale-if-error grace), and its ID in the index.
+// The hash is replaced rather than merged so a tombstone for the same ID
+// doesn't survive, but only if it doesn't hold a newer version: a refill
+// that read the row before an update can't overwrite what the update
+// cached.
+//
+// The ID is only added if the index exists. The index must list every row,
+// so only a full reload (loadAll) may create it; otherwise a single refill
+// after the index expired would leave an index of one.
+func (cr *Cached[T]) queueEntry(pipe cache.Pipeline, v *T) {
+	id := cr.id(v)
+	h, err := cr.hash(v)
+	if err != nil {
+		// Left to be loaded from the database by the next read
+		cr.uncacheable.Add(1)
+		pipe.Del(cr.cfg.Keys.ID(id))
+		return
+	}
+	ttl := cr.cfg.TTLs.Entry.Next()
+	if ttl > 0 {
+		h[expiresAtField] = strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	}
+	pipe.HReplaceIfNewer(cr.cfg.Keys.ID(id), h, versionField, cr.cfg.TTLs.Entry.KeepFor(ttl))
+	pipe.SAddExisting(cr.cfg.Keys.Index(), strconv.FormatUint(uint64(id), 10))
+}
+
+// cacheTombstone remembers that a row doesn't exist, so repeated lookups of
+// the same bad ID stop reaching the database until the tombstone expires.
+func (cr *Cached[T]) cacheTombstone(ctx context.Context, key string) {
+	pipe := cr.cache.Pipeline()
+	if cr.queueTombstone(pipe, key) {
+		_ = pipe.Exec(ctx)
+	}
+}
+
+// queueTombstone adds the writes that cache a tombstone to pipe. It returns
+// false when negative caching is disabled.
+func (cr *Cached[T]) queueTombstone(pipe cache.Pipeline, key string) bool {
+	ttl := cr.cfg.TTLs.Missing.Next()
+	if ttl <= 0 {
+		return false
+	}
+	pipe.HSet(key, map[string]string{tombstoneField: "1"})
+	pipe.Expire(key, ttl)
+	return true
+}
+
+// invalidate tells the other instances that key was written, so they drop
+// their L1 copy. This instance updates its own L1 before calling it.
+func (cr *Cached[T]) invalidate(ctx context.Context, key string) {
+	if err := cr.cfg.Bus.Publish(ctx, key); err != nil {
+		fmt.Println("Invalidation publish failed for", key+":", err)
+	}
+	cr.publish(events.Invalidate, key, "")
+}
+
+// publish sends an event about key to the live event stream.
+func (cr *Cached[T]) publish(typ events.Type, key, detail string) {
+	cr.cfg.Events.Publish(events.Event{Type: typ, Entity: cr.cfg.Entity, Key: key, Detail: detail})
+}
+
+// recordRead counts a read served by tier and publishes it as a hit, or a
+// miss for the database.
+func (cr *Cached[T]) recordRead(ctx context.Context, tier cache.Tier, key string) {
+	cache.RecordTier(ctx, tier)
+	if tier == cache.TierDB {
+		cr.publish(events.Miss, key, "")
+	} else {
+		cr.publish(events.Hit, key, string(tier))
+	}
+}
+
+func (cr *Cached[T]) setL1(key string, v *T) {
+	if cr.cfg.L1 != nil {
+		cr.cfg.L1.Set(key, *v)
+	}
+}
+
+func (cr *Cached[T]) getL1(ctx context.Context, key string) (*T, bool) {
+	if cr.cfg.L1 == nil {
+		return nil, false
+	}
+	v, ok := cr.cfg.L1.Get(key)
+	if !ok {
+		return nil, false
+	}
+	cr.recordRead(ctx, cache.TierL1, key)
+	return &v, true
+}
+
+// drop removes the cached copy of key from the shared cache and L1.
+func (cr *Cached[T]) drop(ctx context.Context, key string) {
+	cr.cache.Del(ctx, key)
+	cr.dropL1(key)
+}
+
+// dropL1 evicts key from this instance's L1. Other instances evict it on
+// the invalidation, which this one ignores.
+func (cr *Cached[T]) dropL1(key string) {
+	if cr.cfg.L1 != nil {
+		cr.cfg.L1.Delete(key)
+	}
+}
+
+// cachedEntry is what the shared cache holds for a row key: the row or a
+// tombstone, and when it goes stale.
+type cachedEntry[T any] struct {
+	value     *T
+	missing   bool
+	expiresAt time.Time // zero when the entry doesn't go stale
+}
+
+// readCached looks a row up in the shared cache. ok is false on a miss; a
+// tombstone is a hit that returns the NotFound error.
+func (cr *Cached[T]) readCached(ctx context.Context, key string) (cachedEntry[T], bool) {
+	h, err := cr.cache.HGetAll(ctx, key)
+	// HGetAll returns an empty map if not found
+	if err != nil {
+		return cachedEntry[T]{}, false
+	}
+	return cr.fromHash(h)
+}
+
+// fromHash decodes a row hash; ok is false for an empty or malformed one.
+func (cr *Cached[T]) fromHash(h map[string]string) (cachedEntry[T], bool) {
+	if h[tombstoneField] != "" {
+		return cachedEntry[T]{missing: true}, true
+	}
+	v, ok := cr.cfg.Codec.Decode(h)
+	if !ok {
+		return cachedEntry[T]{}, false
+	}
+	if cr.cfg.Version != nil {
+		// Hashes cached before the model had versions read as version 0
+		version, _ := strconv.ParseUint(h[versionField], 10, 64)
+		cr.setVersion(v, version)
+	}
+	e := cachedEntry[T]{value: v}
+	if ms, err := strconv.ParseInt(h[expiresAtField], 10, 64); err == nil {
+		e.expiresAt = time.UnixMilli(ms)
+	}
+	return e, true
+}
+
+// result returns a copy of the cached row, or the NotFound error for a
+// tombstone.
+func (cr *Cached[T]) result(e cachedEntry[T]) (*T, error) {
+	if e.missing {
+		return nil, cr.cfg.NotFound
+	}
+	v := *e.value
+	return &v, nil
+}
+
+// GetTTL reports the remaining TTL of the hash of a row and of the ID
+// index, for debugging expiry settings.
+func (cr *Cached[T]) GetTTL(ctx context.Context, id uint) ([]cache.KeyTTL, error) {
+	key := cr.cfg.Keys.ID(id)
+	ttl, err := cr.cache.TTL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	idxTTL, err := cr.cache.TTL(ctx, cr.cfg.Keys.Index())
+	if err != nil {
+		return nil, err
+	}
+
+	return []cache.KeyTTL{
+		cache.NewKeyTTL(key, ttl, cr.cfg.TTLs.Entry),
+		cache.NewKeyTTL(cr.cfg.Keys.Index(), idxTTL, cr.cfg.TTLs.Index),
+	}, nil
+}
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+// GetMany returns the rows with ids that exist, sorted by ID, in a fixed
+// number of round trips, see byIDs.
+func (cr *Cached[T]) GetMany(ctx context.Context, ids []uint) ([]T, error) {
+	s := cr.strategyFor(strategy.OpGet)
+	ctx = cr.withOp(ctx, strategy.OpGet, s)
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if s == strategy.NoCache {
+		return cr.queryMany(ctx, ids)
+	}
+	return cr.byIDs(ctx, ids)
+}
+
+// byMembers resolves index members, see byIDs.
+func (cr *Cached[T]) byMembers(ctx context.Context, members []string) ([]T, error) {
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		if id, err := strconv.ParseUint(m, 10, 64); err == nil && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	}
+	slices.Sort(ids)
+	return cr.byIDs(ctx, ids)
+}
+
+// byIDs resolves sorted IDs in a fixed number of round trips, whatever
+// their number: L1 first, then every remaining hash in one pipeline, then
+// all misses in one WHERE id IN query, backfilled in one pipeline.
+func (cr *Cached[T]) byIDs(ctx context.Context, ids []uint) ([]T, error) {
+	found := make(map[uint]T, len(ids))
+	var keys []string
+	var keyIDs []uint
+	for _, id := range ids {
+		key := cr.cfg.Keys.ID(id)
+		if v, ok := cr.getL1(ctx, key); ok {
+			found[id] = *v
+			continue
+		}
+		keys = append(keys, key)
+		keyIDs = append(keyIDs, id)
+	}
+
+	var misses []uint
+	stale := make(map[uint]*T)
+	if len(keys) > 0 {
+		hashes, err := cr.cache.HGetAllMany(ctx, keys)
+		if err != nil {
+			// Cache unavailable: everything comes from the database
+			hashes = make([]map[string]string, len(keys))
+		}
+		for i, h := range hashes {
+			id := keyIDs[i]
+			cached, ok := cr.fromHash(h)
+			switch {
+			case !ok:
+				misses = append(misses, id)
+			case cached.missing:
+				// Deleted row the index still lists
+			case cr.freshness(cached) == entryStale:
+				misses = append(misses, id)
+				stale[id] = cached.value
+			default:
+				cr.recordRead(ctx, cache.TierL2, keys[i])
+				if cr.freshness(cached) == entryRefreshDue {
+					cr.refresh(id)
+				}
+				cr.setL1(keys[i], cached.value)
+				found[id] = *cached.value
+			}
+		}
+	}
+
+	if len(misses) > 0 {
+		loaded, err := cr.fetchMany(ctx, misses)
+		if err != nil {
+			// Stale-if-error, as long as every miss has a stale copy
+			for _, id := range misses {
+				v, ok := stale[id]
+				if !ok {
+					return nil, err
+				}
+				found[id] = *v
+				cr.staleServed.Add(1)
+				cache.RecordStale(ctx)
+			}
+		}
+		for i := range loaded {
+			found[cr.id(&loaded[i])] = loaded[i]
+		}
+	}
+
+	rows := make([]T, 0, len(found))
+	for _, id := range ids {
+		if v, ok := found[id]; ok {
+			rows = append(rows, v)
+		}
+	}
+	return rows, nil
+}
+
+// findMany loads the rows with ids from the database, chunked to keep the
+// number of placeholders per query bounded.
+func (cr *Cached[T]) findMany(ctx context.Context, ids []uint) ([]T, error) {
+	var rows []T
+	for start := 0; start < len(ids); start += indexChunk {
+		var chunk []T
+		err := cr.db.WithContext(ctx).
+			Where("id IN ?", ids[start:min(start+indexChunk, len(ids))]).
+			Order("id").
+			Find(&chunk).Error
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, chunk...)
+	}
+	return rows, nil
+}
+
+// fetchMany loads rows with WHERE id IN and backfills the cache in one
+// pipeline. IDs without a row are tombstoned and removed from the index.
+func (cr *Cached[T]) fetchMany(ctx context.Context, ids []uint) ([]T, error) {
+	rows, err := cr.findMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(map[uint]bool, len(rows))
+	pipe := cr.cache.Pipeline()
+	for i := range rows {
+		v := &rows[i]
+		id := cr.id(v)
+		loaded[id] = true
+		key := cr.cfg.Keys.ID(id)
+		cr.recordRead(ctx, cache.TierDB, key)
+		cr.queueEntry(pipe, v)
+		cr.setL1(key, v)
+	}
+	for _, id := range ids {
+		if !loaded[id] {
+			cr.queueTombstone(pipe, cr.cfg.Keys.ID(id))
+			pipe.SRem(cr.cfg.Keys.Index(), strconv.FormatUint(uint64(id), 10))
+		}
+	}
+	_ = pipe.Exec(ctx)
+	return rows, nil
+}
@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"github.com/wailman24/Caching.git/pkg/cache/codec"
+)
+
+// valueField holds the encoded entity in the hashes of an EncodedCodec.
+const valueField = "value"
+
+// EncodedCodec stores an entity as one value encoded by a codec.Codec,
+// which suits nested models that don't map to flat hash fields. The value
+// sits next to the version, expires_at and tombstone fields Cached keeps,
+// and carries its own codec marker: values written with another format or
+// compression are still decoded, so the codec can be switched without
+// flushing the cache.
+type EncodedCodec[T any] struct {
+	codec *codec.Codec
+	id    func(v *T) *uint
+}
+
+// Encoded returns an EncodedCodec writing with c. id is the ID of
+// CachedConfig, a decoded value without one is treated as malformed.
+func Encoded[T any](c *codec.Codec, id func(v *T) *uint) EncodedCodec[T] {
+	return EncodedCodec[T]{codec: c, id: id}
+}
+
+func (ec EncodedCodec[T]) Encode(v *T) (map[string]string, error) {
+	data, err := ec.codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{valueField: string(data)}, nil
+}
+
+func (ec EncodedCodec[T]) Decode(h map[string]string) (*T, bool) {
+	data, ok := h[valueField]
+	if !ok {
+		return nil, false
+	}
+	v := new(T)
+	if err := codec.Decode([]byte(data), v); err != nil || *ec.id(v) == 0 {
+		return nil, false
+	}
+	return v, true
+}
+
+// Stats returns the counters of the codec writing the values.
+func (ec EncodedCodec[T]) Stats() codec.Stats {
+	return ec.codec.Stats()
+}
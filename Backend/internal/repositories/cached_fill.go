@@ -0,0 +1,164 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/wailman24/Caching.git/internal/writebehind"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/codec"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
+	"github.com/wailman24/Caching.git/pkg/cache/singleflight"
+)
+
+// leasePollInterval is how often an instance waiting on another instance's
+// fill lease checks whether the cache has been filled.
+const leasePollInterval = 25 * time.Millisecond
+
+// withFillLease runs load while holding the cross-instance fill lease for
+// key. When another instance holds the lease, it polls check until that
+// instance has filled the cache, and only loads by itself once the lease
+// has expired (the holder crashed or is too slow).
+//
+// Within a process the singleflight groups already let a single goroutine
+// get here per key; the lease extends that to every replica.
+func withFillLease[T, R any](ctx context.Context, cr *Cached[T], key string, check func() (R, error, bool), load func() (R, error)) (R, error) {
+	lease := cr.cfg.FillLease
+	if lease <= 0 {
+		return load()
+	}
+
+	leaseKey := cr.cfg.Keys.Lease(key)
+	deadline := time.Now().Add(lease)
+	waited := false
+	for {
+		release, ok, err := cr.takeLease(ctx, leaseKey)
+		if err != nil {
+			// Cache unavailable, nothing to coordinate through
+			return load()
+		}
+		if ok {
+			defer release()
+			// The previous holder may have filled the cache just before
+			// releasing the lease
+			if v, err, found := check(); found {
+				return v, err
+			}
+			return load()
+		}
+
+		if !waited {
+			waited = true
+			cr.leaseWaits.Add(1)
+		}
+		if v, err, found := check(); found {
+			return v, err
+		}
+		if time.Now().After(deadline) {
+			return load()
+		}
+
+		select {
+		case <-ctx.Done():
+			var zero R
+			return zero, ctx.Err()
+		case <-time.After(leasePollInterval):
+		}
+	}
+}
+
+// takeLease tries to take the fill lease at leaseKey, for FillLease. The
+// lease holds a random token, and release only deletes it while it still
+// holds ours: after a load slower than the lease, it may belong to another
+// instance by now.
+func (cr *Cached[T]) takeLease(ctx context.Context, leaseKey string) (release func(), ok bool, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(b)
+	ok, err = cr.cache.SetNX(ctx, leaseKey, token, cr.cfg.FillLease)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() { cr.cache.DelIfEqual(ctx, leaseKey, token) }, true, nil
+}
+
+// EntityCacheStats gathers the cache counters of a repository.
+type EntityCacheStats struct {
+	Reads       cache.ReadCounts   `json:"reads"`
+	L1          *local.Stats       `json:"l1,omitempty"`
+	Coalescing  CoalescingStats    `json:"coalescing"`
+	Freshness   FreshnessStats     `json:"freshness"`
+	WriteBehind *writebehind.Stats `json:"write_behind,omitempty"`
+	// Invalidation is the bus shared by every repository on this instance.
+	Invalidation *invalidation.Stats  `json:"invalidation,omitempty"`
+	Tracking     *cache.TrackingStats `json:"tracking,omitempty"`
+	Keyspace     *KeyspaceStats       `json:"keyspace,omitempty"`
+	Locks        lock.Stats           `json:"locks"`
+	// Codec is set for entities stored by an EncodedCodec.
+	Codec *codec.Stats `json:"codec,omitempty"`
+	// Uncacheable counts values the codec failed to encode, which were
+	// left to the database instead.
+	Uncacheable int64 `json:"uncacheable"`
+}
+
+// CoalescingStats shows how much load request coalescing saved.
+type CoalescingStats struct {
+	GetByID singleflight.Stats `json:"get_by_id"`
+	GetAll  singleflight.Stats `json:"get_all"`
+	// LeaseWaits counts loads that waited on another instance's fill lease.
+	LeaseWaits int64 `json:"lease_waits"`
+}
+
+// CacheUsage returns the reads and L1 counters, for CacheRepositorie.
+func (cr *Cached[T]) CacheUsage() CacheUsage {
+	usage := CacheUsage{Reads: cr.reads.Counts()}
+	if cr.cfg.L1 != nil {
+		l1 := cr.cfg.L1.Stats()
+		usage.L1 = &l1
+	}
+	return usage
+}
+
+func (cr *Cached[T]) Stats(ctx context.Context) EntityCacheStats {
+	usage := cr.CacheUsage()
+	stats := EntityCacheStats{Reads: usage.Reads, L1: usage.L1, Uncacheable: cr.uncacheable.Load()}
+	stats.Coalescing = CoalescingStats{
+		GetByID:    cr.flights.Stats(),
+		GetAll:     cr.listFlights.Stats(),
+		LeaseWaits: cr.leaseWaits.Load(),
+	}
+	stats.Freshness = FreshnessStats{
+		Refreshes:   cr.refreshes.Load(),
+		StaleServed: cr.staleServed.Load(),
+	}
+	if cr.cfg.Queue != nil {
+		wb := cr.cfg.Queue.Stats(ctx)
+		stats.WriteBehind = &wb
+	}
+	if cr.cfg.Bus != nil {
+		inv := cr.cfg.Bus.Stats()
+		stats.Invalidation = &inv
+	}
+	if cr.cfg.Tracker != nil {
+		tr := cr.cfg.Tracker.Stats()
+		stats.Tracking = &tr
+	}
+	if cr.cfg.Keyspace != nil {
+		stats.Keyspace = &KeyspaceStats{
+			KeyspaceStats: cr.cfg.Keyspace.Stats(),
+			IndexPruned:   cr.indexPruned.Load(),
+		}
+	}
+	stats.Locks = cr.cfg.Locks.Stats()
+	if c, ok := cr.cfg.Codec.(interface{ Stats() codec.Stats }); ok {
+		cs := c.Stats()
+		stats.Codec = &cs
+	}
+	return stats
+}
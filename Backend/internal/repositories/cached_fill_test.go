@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+func TestFillLeaseReleasesOnlyOwnLease(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache()
+	pr := NewProductRepositorie(newTestDB(t), mc, WithFillLease(time.Minute))
+	leaseKey := ProductKeys().Lease(ProductKeys().ID(1))
+
+	release, ok, err := pr.takeLease(ctx, leaseKey)
+	if err != nil || !ok {
+		t.Fatalf("takeLease = %v, %v", ok, err)
+	}
+	if _, ok, _ := pr.takeLease(ctx, leaseKey); ok {
+		t.Error("took a lease already held")
+	}
+	release()
+	if _, ok, _ := pr.takeLease(ctx, leaseKey); !ok {
+		t.Error("lease still held after release")
+	}
+
+	// The lease expires during a slow load and another instance takes it
+	mc.Del(ctx, leaseKey)
+	release, _, _ = pr.takeLease(ctx, leaseKey)
+	mc.Del(ctx, leaseKey)
+	mc.SetNX(ctx, leaseKey, "another-instance", time.Minute)
+	release()
+	if ok, _ := mc.DelIfEqual(ctx, leaseKey, "another-instance"); !ok {
+		t.Error("a late release deleted the other instance's lease")
+	}
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// entryFreshness classifies a cached entry against its key family's policy.
+type entryFreshness int
+
+const (
+	entryFresh entryFreshness = iota
+	// still served, but close enough to expiry to reload in the background
+	entryRefreshDue
+	// past its TTL; only kept around for stale-if-error
+	entryStale
+)
+
+// --- STRATEGY: REFRESH-AHEAD / STALE-IF-ERROR ---
+// Refresh-ahead: a hit on an entry about to expire is served from the cache
+// and reloaded in the background, so hot keys are refreshed before they
+// ever miss.
+// Stale-if-error: entries are kept for a grace period after they expire.
+// An expired entry is reloaded like a miss, but if the database fails the
+// stale copy is served instead of an error.
+
+func (cr *Cached[T]) freshness(e cachedEntry[T]) entryFreshness {
+	return freshness(time.Until(e.expiresAt), e.expiresAt.IsZero(), cr.cfg.TTLs.Entry.RefreshAhead)
+}
+
+// indexFreshness checks the ID index. Unlike row hashes the index has no
+// field to hold its expiry, so it is worked out from the key's TTL minus
+// the stale-if-error grace added when it was written.
+func (cr *Cached[T]) indexFreshness(ctx context.Context) entryFreshness {
+	policy := cr.cfg.TTLs.Index
+	if policy.RefreshAhead <= 0 && policy.StaleIfError <= 0 {
+		return entryFresh
+	}
+	ttl, err := cr.cache.TTL(ctx, cr.cfg.Keys.Index())
+	if err != nil {
+		return entryFresh
+	}
+	return freshness(ttl-policy.StaleIfError, ttl < 0, policy.RefreshAhead)
+}
+
+func freshness(left time.Duration, noExpiry bool, refreshAhead time.Duration) entryFreshness {
+	switch {
+	case noExpiry:
+		return entryFresh
+	case left <= 0:
+		return entryStale
+	case left < refreshAhead:
+		return entryRefreshDue
+	default:
+		return entryFresh
+	}
+}
+
+// refresh reloads a row in the background. Only one refresh per key runs
+// in this process, and with a fill lease only one across instances.
+func (cr *Cached[T]) refresh(id uint) {
+	key := cr.cfg.Keys.ID(id)
+	cr.refreshInBackground(key, func(ctx context.Context) error {
+		// Share the load with misses racing on the same key
+		v, err, _ := cr.flights.Do(ctx, key, func() (*T, error) {
+			return cr.fetch(ctx, id)
+		})
+		if err == nil {
+			cr.setL1(key, v)
+		}
+		return err
+	})
+}
+
+// refreshIndex rebuilds the index in the background.
+func (cr *Cached[T]) refreshIndex() {
+	index := cr.cfg.Keys.Index()
+	cr.refreshInBackground(index, func(ctx context.Context) error {
+		_, err, _ := cr.listFlights.Do(ctx, index, func() ([]T, error) {
+			return cr.fetchAll(ctx)
+		})
+		return err
+	})
+}
+
+func (cr *Cached[T]) refreshInBackground(key string, refresh func(ctx context.Context) error) {
+	if _, running := cr.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer cr.refreshing.Delete(key)
+		ctx := context.Background()
+
+		if cr.cfg.FillLease > 0 {
+			// Another instance is already reloading this key
+			release, ok, err := cr.takeLease(ctx, cr.cfg.Keys.Lease(key))
+			if err != nil || !ok {
+				return
+			}
+			defer release()
+		}
+
+		cr.refreshes.Add(1)
+		if err := refresh(ctx); err != nil {
+			fmt.Println("Refresh-ahead failed for", key+":", err)
+		}
+	}()
+}
+
+// FreshnessStats counts refresh-ahead and stale-if-error activity.
+type FreshnessStats struct {
+	// Refreshes is how many background reloads were started.
+	Refreshes int64 `json:"refreshes"`
+	// StaleServed is how many reads got an expired entry because
+	// reloading it failed.
+	StaleServed int64 `json:"stale_served"`
+}
@@ -0,0 +1,112 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+)
+
+// KeyspaceStats shows the keys Redis removed on its own, see
+// CachedConfig.Keyspace.
+type KeyspaceStats struct {
+	cache.KeyspaceStats
+	// IndexPruned counts IDs dropped from the index because their hash
+	// disappeared and the row no longer exists.
+	IndexPruned int64 `json:"index_pruned"`
+}
+
+// keyEventTypes is the live event published for each kind of key event.
+var keyEventTypes = map[string]events.Type{
+	cache.KeyEvicted: events.Eviction,
+	cache.KeyExpired: events.Expiry,
+	cache.KeyDeleted: events.Delete,
+}
+
+// handleKeyEvents is called with the keys Redis evicted, expired or
+// deleted. The row hashes among them are published on the event stream,
+// and their IDs are checked against the database: the index lists every
+// row, cached or not, so only the IDs of rows that are gone are removed
+// from it. A row that still exists is just a miss, reloaded on the next
+// read.
+func (cr *Cached[T]) handleKeyEvents(ctx context.Context, evs []cache.KeyEvent) {
+	var keys []string
+	var kinds []string
+	var ids []uint
+	for _, ev := range evs {
+		id, ok := cr.cfg.Keys.ParseID(ev.Key)
+		if !ok {
+			continue
+		}
+		keys = append(keys, ev.Key)
+		kinds = append(kinds, ev.Kind)
+		ids = append(ids, id)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	// Replacing a hash deletes it first (HReplaceIfNewer), so most del
+	// events are writes: only keys still missing now were removed
+	hashes, err := cr.cache.HGetAllMany(ctx, keys)
+	if err != nil {
+		fmt.Println("Keyspace events: could not check removed", cr.cfg.Entity, "keys:", err)
+		return
+	}
+	var gone []uint
+	for i, h := range hashes {
+		if len(h) > 0 {
+			continue
+		}
+		cr.publish(keyEventTypes[kinds[i]], keys[i], "redis")
+		gone = append(gone, ids[i])
+	}
+	if len(gone) > 0 {
+		cr.pruneIndex(ctx, gone)
+	}
+}
+
+// pruneIndex removes from the index the IDs among ids that have no row.
+func (cr *Cached[T]) pruneIndex(ctx context.Context, ids []uint) {
+	orphans, err := cr.missingRows(ctx, ids)
+	if err != nil {
+		fmt.Println("Keyspace events: could not check removed", cr.cfg.Entity, "keys:", err)
+		return
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	members := make([]string, len(orphans))
+	for i, id := range orphans {
+		members[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	index := cr.cfg.Keys.Index()
+	if err := cr.cache.SRem(ctx, index, members...); err != nil {
+		fmt.Println("Keyspace events: could not prune", index+":", err)
+		return
+	}
+	cr.indexPruned.Add(int64(len(orphans)))
+	fmt.Println("Removed deleted rows from", index+":", members)
+}
+
+// missingRows returns the IDs among ids that have no row.
+func (cr *Cached[T]) missingRows(ctx context.Context, ids []uint) ([]uint, error) {
+	var existing []uint
+	if err := cr.db.WithContext(ctx).Model(new(T)).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return nil, err
+	}
+	exists := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		exists[id] = true
+	}
+
+	var missing []uint
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,295 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"gorm.io/gorm"
+)
+
+// --- STRATEGY: READ-THROUGH ---
+// GetByID and List go through shared loaders: concurrent misses are
+// coalesced, leased across instances and refreshed ahead of expiry.
+// Cache-aside and no-cache are in cached_strategy.go.
+
+// GetByID returns the row with id: from L1, the shared cache, or the
+// database.
+func (cr *Cached[T]) GetByID(ctx context.Context, id uint) (*T, error) {
+	s := cr.strategyFor(strategy.OpGet)
+	ctx = cr.withOp(ctx, strategy.OpGet, s)
+	switch s {
+	case strategy.NoCache:
+		return cr.query(ctx, id)
+	case strategy.CacheAside:
+		return cr.getCacheAside(ctx, id)
+	}
+
+	key := cr.cfg.Keys.ID(id)
+
+	// Check the in-process tier first
+	if v, ok := cr.getL1(ctx, key); ok {
+		return v, nil
+	}
+
+	// Check Redis Hash
+	cached, found := cr.readCached(ctx, key)
+	if found && cr.freshness(cached) != entryStale {
+		cr.recordRead(ctx, cache.TierL2, key)
+		if cr.freshness(cached) == entryRefreshDue {
+			cr.refresh(id)
+		}
+		if cached.value != nil {
+			cr.setL1(key, cached.value)
+		}
+		return cr.result(cached)
+	}
+
+	cr.recordRead(ctx, cache.TierDB, key)
+
+	// Only one loader per key runs at a time, concurrent misses wait for it
+	v, err, shared := cr.flights.Do(ctx, key, func() (*T, error) {
+		return cr.load(context.WithoutCancel(ctx), id)
+	})
+	if shared {
+		cache.RecordCoalesced(ctx)
+	}
+	if err != nil {
+		// Stale-if-error: an expired copy is better than no answer, unless
+		// the row is known to be gone
+		if found && cached.value != nil && !errors.Is(err, cr.cfg.NotFound) {
+			fmt.Println("Serving stale", cr.cfg.Entity+":", id, err)
+			cr.staleServed.Add(1)
+			cache.RecordStale(ctx)
+			return cached.value, nil
+		}
+		return nil, err
+	}
+
+	cr.setL1(key, v)
+	// Callers sharing a load each get their own copy
+	res := *v
+	return &res, nil
+}
+
+// List returns every row, sorted by ID, resolved through the ID index.
+func (cr *Cached[T]) List(ctx context.Context) ([]T, error) {
+	s := cr.strategyFor(strategy.OpList)
+	ctx = cr.withOp(ctx, strategy.OpList, s)
+	switch s {
+	case strategy.NoCache:
+		return cr.queryAll(ctx)
+	case strategy.CacheAside:
+		return cr.listCacheAside(ctx)
+	}
+
+	index := cr.cfg.Keys.Index()
+
+	// Get IDs from the Redis Index
+	members, _ := cr.cache.SMembers(ctx, index)
+	if len(members) == 0 {
+		// Cache MISS for the set
+		cr.recordRead(ctx, cache.TierDB, index)
+
+		// Concurrent cold reads share a single full table scan
+		rows, err, shared := cr.listFlights.Do(ctx, index, func() ([]T, error) {
+			return cr.loadAll(context.WithoutCancel(ctx))
+		})
+		if shared {
+			cache.RecordCoalesced(ctx)
+		}
+		return rows, err
+	}
+	//Cache HIT for the set
+	switch cr.indexFreshness(ctx) {
+	case entryStale:
+		cr.recordRead(ctx, cache.TierDB, index)
+		rows, err, shared := cr.listFlights.Do(ctx, index, func() ([]T, error) {
+			return cr.loadAll(context.WithoutCancel(ctx))
+		})
+		if shared {
+			cache.RecordCoalesced(ctx)
+		}
+		if err == nil {
+			return rows, nil
+		}
+		// Stale-if-error: the expired index is better than no answer
+		fmt.Println("Serving stale", index+":", err)
+		cr.staleServed.Add(1)
+		cache.RecordStale(ctx)
+	case entryRefreshDue:
+		cr.refreshIndex()
+	}
+
+	return cr.byMembers(ctx, members)
+}
+
+// GetBy returns the row whose column, one of the Lookups, holds value. The
+// cache maps the value to the row's ID, so a hit costs two cache reads and
+// a miss one indexed query. The mapping is checked against the row it
+// points to, since it isn't updated when the column changes.
+func (cr *Cached[T]) GetBy(ctx context.Context, column, value string) (*T, error) {
+	get, ok := cr.cfg.Lookups[column]
+	if !ok {
+		return nil, fmt.Errorf("%s has no cached lookup by %s", cr.cfg.Entity, column)
+	}
+	s := cr.strategyFor(strategy.OpGet)
+	ctx = cr.withOp(ctx, strategy.OpGet, s)
+	key := cr.cfg.Keys.Lookup(column, value)
+
+	if s != strategy.NoCache {
+		h, _ := cr.cache.HGetAll(ctx, key)
+		if id, err := strconv.ParseUint(h["id"], 10, 64); err == nil && id > 0 {
+			v, err := cr.GetByID(ctx, uint(id))
+			if err == nil && get(v) == value {
+				return v, nil
+			}
+			if err != nil && !errors.Is(err, cr.cfg.NotFound) {
+				return nil, err
+			}
+		}
+	}
+
+	cr.recordRead(ctx, cache.TierDB, key)
+	var v T
+	err := cr.db.WithContext(ctx).Where(column+" = ?", value).First(&v).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Not tombstoned: the value is likely to be taken soon, e.g. an
+		// email right after a failed login
+		return nil, cr.cfg.NotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s == strategy.NoCache {
+		return &v, nil
+	}
+
+	pipe := cr.cache.Pipeline()
+	cr.queueEntry(pipe, &v)
+	pipe.HSet(key, map[string]string{"id": strconv.FormatUint(uint64(cr.id(&v)), 10)})
+	if ttl := cr.cfg.TTLs.Entry.Next(); ttl > 0 {
+		pipe.Expire(key, ttl)
+	}
+	_ = pipe.Exec(ctx)
+	cr.publish(events.Write, key, "fill")
+	return &v, nil
+}
+
+// load reads a row from the database and refills the cache. With a fill
+// lease, instances racing on the same miss wait for the one holding it.
+func (cr *Cached[T]) load(ctx context.Context, id uint) (*T, error) {
+	key := cr.cfg.Keys.ID(id)
+
+	check := func() (*T, error, bool) {
+		cached, ok := cr.readCached(ctx, key)
+		if !ok || cr.freshness(cached) == entryStale {
+			return nil, nil, false
+		}
+		v, err := cr.result(cached)
+		return v, err, true
+	}
+	return withFillLease(ctx, cr, key, check, func() (*T, error) {
+		return cr.fetch(ctx, id)
+	})
+}
+
+// fetch reads a row from the database and caches it, or caches a tombstone
+// if it doesn't exist.
+func (cr *Cached[T]) fetch(ctx context.Context, id uint) (*T, error) {
+	key := cr.cfg.Keys.ID(id)
+
+	var v T
+	err := cr.db.WithContext(ctx).First(&v, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Negative caching: protects the database from scans over invalid
+		// IDs
+		cr.cacheTombstone(ctx, key)
+		return nil, cr.cfg.NotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Refill Cache
+	pipe := cr.cache.Pipeline()
+	cr.queueEntry(pipe, &v)
+	_ = pipe.Exec(ctx)
+	cr.publish(events.Write, key, "fill")
+	return &v, nil
+}
+
+// loadAll rebuilds the index and every row hash from the database.
+func (cr *Cached[T]) loadAll(ctx context.Context) ([]T, error) {
+	check := func() ([]T, error, bool) {
+		members, err := cr.cache.SMembers(ctx, cr.cfg.Keys.Index())
+		if err != nil || len(members) == 0 || cr.indexFreshness(ctx) == entryStale {
+			return nil, nil, false
+		}
+		rows, err := cr.byMembers(ctx, members)
+		return rows, err, true
+	}
+	return withFillLease(ctx, cr, cr.cfg.Keys.Index(), check, func() ([]T, error) {
+		return cr.fetchAll(ctx)
+	})
+}
+
+// fetchAll reads every row from the database and rebuilds the cache.
+func (cr *Cached[T]) fetchAll(ctx context.Context) ([]T, error) {
+	var rows []T
+	if err := cr.db.WithContext(ctx).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	pipe := cr.cache.Pipeline()
+	ids := make([]string, 0, len(rows))
+	for i := range rows {
+		v := &rows[i]
+		id := cr.id(v)
+		cr.queueEntry(pipe, v)
+		cr.setL1(cr.cfg.Keys.ID(id), v)
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+	// Write-behind creates not flushed yet are in the index but not in
+	// rows: rebuilding it now would drop them from the list
+	if !cr.writesQueued(ctx) {
+		cr.queueIndex(pipe, ids)
+	}
+	_ = pipe.Exec(ctx)
+	cr.publish(events.Write, cr.cfg.Keys.Index(), fmt.Sprintf("fill %d rows", len(rows)))
+	return rows, nil
+}
+
+// writesQueued reports whether write-behind mutations may still be on
+// their way to the database, in which case the cache is ahead of it. It
+// errs on the side of true when the queue can't tell.
+func (cr *Cached[T]) writesQueued(ctx context.Context) bool {
+	if cr.cfg.Queue == nil {
+		return false
+	}
+	queued, err := cr.cfg.Queue.Queued(ctx)
+	return err != nil || queued
+}
+
+// indexChunk caps the members sent in one SADD when rebuilding the index.
+const indexChunk = 1000
+
+// queueIndex adds the writes that rebuild the index from scratch to pipe.
+// Its TTL is set here only, so adding rows doesn't keep pushing its expiry
+// back.
+func (cr *Cached[T]) queueIndex(pipe cache.Pipeline, ids []string) {
+	index := cr.cfg.Keys.Index()
+	pipe.Del(index)
+	if len(ids) == 0 {
+		return
+	}
+	for start := 0; start < len(ids); start += indexChunk {
+		pipe.SAdd(index, ids[start:min(start+indexChunk, len(ids))]...)
+	}
+	if ttl := cr.cfg.TTLs.Index.Next(); ttl > 0 {
+		pipe.Expire(index, cr.cfg.TTLs.Index.KeepFor(ttl))
+	}
+}
@@ -0,0 +1,184 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/reconcile"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"gorm.io/gorm"
+)
+
+// Reconcile compares every row to its hash and to the ID index, and
+// repairs what differs unless opts.DryRun.
+//
+// A row that isn't cached is not drift, the cache is filled lazily. While
+// write-behind mutations are queued the cache is legitimately ahead of the
+// database, so the scan is skipped.
+func (cr *Cached[T]) Reconcile(ctx context.Context, opts reconcile.Options) (reconcile.Report, error) {
+	report := reconcile.NewReport(cr.cfg.Entity, opts)
+	if cr.writesQueued(ctx) {
+		report.Skipped = "write-behind mutations are still queued"
+		return report, nil
+	}
+
+	members, err := cr.cache.SMembers(ctx, cr.cfg.Keys.Index())
+	if err != nil {
+		return report, err
+	}
+	// An absent index is rebuilt on the next List, not drift
+	indexed := make(map[string]bool, len(members))
+	for _, m := range members {
+		indexed[m] = true
+	}
+
+	seen := make(map[string]bool, len(members))
+	var stale []uint // mismatches and tombstones
+	var unindexed []string
+
+	var batch []T
+	err = cr.db.WithContext(ctx).Order("id").FindInBatches(&batch, opts.BatchSize, func(tx *gorm.DB, _ int) error {
+		keys := make([]string, len(batch))
+		for i := range batch {
+			keys[i] = cr.cfg.Keys.ID(cr.id(&batch[i]))
+		}
+		hashes, err := cr.cache.HGetAllMany(ctx, keys)
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			v := &batch[i]
+			id := cr.id(v)
+			report.Checked++
+			idStr := strconv.FormatUint(uint64(id), 10)
+			seen[idStr] = true
+			if len(indexed) > 0 && !indexed[idStr] {
+				report.Add(reconcile.Drift{ID: id, Kind: reconcile.DriftMissingFromIndex})
+				unindexed = append(unindexed, idStr)
+			}
+
+			cached, ok := cr.fromHash(hashes[i])
+			switch {
+			case !ok:
+			case cached.missing:
+				report.Add(reconcile.Drift{ID: id, Kind: reconcile.DriftTombstone, Stored: cr.describe(v)})
+				stale = append(stale, id)
+			case !cr.same(cached.value, v):
+				report.Add(reconcile.Drift{
+					ID:     id,
+					Kind:   reconcile.DriftMismatch,
+					Cached: cr.describe(cached.value),
+					Stored: cr.describe(v),
+				})
+				stale = append(stale, id)
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return report, err
+	}
+
+	var orphans []uint
+	for _, m := range members {
+		if !seen[m] {
+			id, _ := strconv.ParseUint(m, 10, 64)
+			report.Add(reconcile.Drift{ID: uint(id), Kind: reconcile.DriftOrphanInIndex})
+			orphans = append(orphans, uint(id))
+		}
+	}
+
+	if !opts.DryRun {
+		report.Repaired = cr.repair(ctx, stale, unindexed, orphans)
+	}
+	return report, nil
+}
+
+// repair fixes the drift found by Reconcile and returns how many entries
+// it repaired. Everything is checked again first: a write may have
+// happened since the scan read it.
+func (cr *Cached[T]) repair(ctx context.Context, stale []uint, unindexed []string, orphans []uint) int64 {
+	var repaired int64
+
+	for _, id := range stale {
+		if cr.repairEntry(ctx, id) {
+			repaired++
+		}
+	}
+
+	if len(unindexed) > 0 {
+		pipe := cr.cache.Pipeline()
+		pipe.SAddExisting(cr.cfg.Keys.Index(), unindexed...)
+		if pipe.Exec(ctx) == nil {
+			repaired += int64(len(unindexed))
+		}
+	}
+
+	if len(orphans) > 0 {
+		missing, err := cr.missingRows(ctx, orphans)
+		if err != nil {
+			return repaired
+		}
+		for _, id := range missing {
+			key := cr.cfg.Keys.ID(id)
+			pipe := cr.cache.Pipeline()
+			pipe.SRem(cr.cfg.Keys.Index(), strconv.FormatUint(uint64(id), 10))
+			pipe.Del(key)
+			if pipe.Exec(ctx) == nil {
+				cr.dropL1(key)
+				cr.invalidate(ctx, key)
+				repaired++
+			}
+		}
+	}
+	return repaired
+}
+
+// repairEntry re-caches a row from the database. It takes the same lock as
+// Update, so it can't overwrite an update made meanwhile with an older
+// row, and skips the row if the lock is held.
+func (cr *Cached[T]) repairEntry(ctx context.Context, id uint) bool {
+	lk, err := cr.cfg.Locks.TryAcquire(ctx, cr.cfg.Keys.Lock(id))
+	if err != nil {
+		return false
+	}
+	defer lk.Release(context.WithoutCancel(ctx))
+
+	key := cr.cfg.Keys.ID(id)
+	var v T
+	if err := cr.db.WithContext(ctx).First(&v, id).Error; err != nil {
+		return false
+	}
+	if cached, ok := cr.readCached(ctx, key); ok && !cached.missing && cr.same(cached.value, &v) {
+		// Fixed since the scan
+		return false
+	}
+
+	pipe := cr.cache.Pipeline()
+	cr.queueEntry(pipe, &v)
+	if pipe.Exec(ctx) != nil {
+		return false
+	}
+	cr.setL1(key, &v)
+	cr.publish(events.Write, key, "repair")
+	cr.invalidate(ctx, key)
+	return true
+}
+
+// same compares two rows by what the cache stores of them, both encoded
+// with the current codec.
+func (cr *Cached[T]) same(a, b *T) bool {
+	ha, err := cr.hash(a)
+	if err != nil {
+		return false
+	}
+	hb, err := cr.hash(b)
+	return err == nil && maps.Equal(ha, hb)
+}
+
+func (cr *Cached[T]) describe(v *T) string {
+	return fmt.Sprintf("%+v", *v)
+}
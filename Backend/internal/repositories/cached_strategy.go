@@ -0,0 +1,156 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"gorm.io/gorm"
+)
+
+// strategyFor returns the current strategy of op. Without a registry the
+// strategies are fixed by the configuration the repository was built with.
+func (cr *Cached[T]) strategyFor(op strategy.Operation) strategy.Strategy {
+	if cr.cfg.Strategies != nil {
+		if s := cr.cfg.Strategies.Get(cr.cfg.Entity, op); s != "" {
+			return s
+		}
+	}
+	switch {
+	case op.IsRead():
+		return strategy.ReadThrough
+	case cr.cfg.Queue != nil:
+		return strategy.WriteBehind
+	default:
+		return strategy.WriteThrough
+	}
+}
+
+// writeStrategy is strategyFor for writes, falling back to write-through
+// when write-behind is selected but this repository has no queue.
+func (cr *Cached[T]) writeStrategy(op strategy.Operation) strategy.Strategy {
+	s := cr.strategyFor(op)
+	if s == strategy.WriteBehind && cr.cfg.Queue == nil {
+		return strategy.WriteThrough
+	}
+	return s
+}
+
+// withOp tags the reads of op, served with s, for the cache metrics and
+// counts them in cr.reads.
+func (cr *Cached[T]) withOp(ctx context.Context, op strategy.Operation, s strategy.Strategy) context.Context {
+	ctx = cache.WithCounter(ctx, &cr.reads)
+	return cache.WithOp(ctx, cache.Op{
+		Entity:    cr.cfg.Entity,
+		Operation: string(op),
+		Strategy:  string(s),
+	})
+}
+
+// --- STRATEGY: CACHE-ASIDE ---
+// The textbook pattern: check the cache, and on a miss read the database
+// and fill the cache. Nothing coordinates concurrent misses, so every one
+// of them queries the database, and entries are not refreshed ahead of
+// expiry. Compare with the read-through default in GetByID.
+func (cr *Cached[T]) getCacheAside(ctx context.Context, id uint) (*T, error) {
+	key := cr.cfg.Keys.ID(id)
+
+	if v, ok := cr.getL1(ctx, key); ok {
+		return v, nil
+	}
+
+	if cached, found := cr.readCached(ctx, key); found && cr.freshness(cached) != entryStale {
+		cr.recordRead(ctx, cache.TierL2, key)
+		if cached.value != nil {
+			cr.setL1(key, cached.value)
+		}
+		return cr.result(cached)
+	}
+
+	cr.recordRead(ctx, cache.TierDB, key)
+	v, err := cr.fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cr.setL1(key, v)
+	return v, nil
+}
+
+func (cr *Cached[T]) listCacheAside(ctx context.Context) ([]T, error) {
+	index := cr.cfg.Keys.Index()
+	members, _ := cr.cache.SMembers(ctx, index)
+	if len(members) > 0 && cr.indexFreshness(ctx) != entryStale {
+		return cr.byMembers(ctx, members)
+	}
+
+	cr.recordRead(ctx, cache.TierDB, index)
+	return cr.fetchAll(ctx)
+}
+
+// --- STRATEGY: NO-CACHE ---
+// Reads go straight to the database, as a baseline for the other
+// strategies.
+func (cr *Cached[T]) query(ctx context.Context, id uint) (*T, error) {
+	cr.recordRead(ctx, cache.TierDB, cr.cfg.Keys.ID(id))
+	var v T
+	err := cr.db.WithContext(ctx).First(&v, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, cr.cfg.NotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (cr *Cached[T]) queryAll(ctx context.Context) ([]T, error) {
+	cr.recordRead(ctx, cache.TierDB, cr.cfg.Keys.Index())
+	var rows []T
+	if err := cr.db.WithContext(ctx).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (cr *Cached[T]) queryMany(ctx context.Context, ids []uint) ([]T, error) {
+	for _, id := range ids {
+		cr.recordRead(ctx, cache.TierDB, cr.cfg.Keys.ID(id))
+	}
+	return cr.findMany(ctx, ids)
+}
+
+// --- STRATEGY: WRITE-AROUND ---
+// Writes go to the database only and the cached copy is dropped, so the
+// row is cached again by the next read instead of by the write. No-cache
+// writes work the same way: the cache must not keep a value the database no
+// longer has, in case reads are switched back to a cached strategy.
+func (cr *Cached[T]) createWriteAround(ctx context.Context, v *T) error {
+	if err := cr.db.WithContext(ctx).Create(v).Error; err != nil {
+		return err
+	}
+
+	// Drop any tombstone for the new ID. The index only lists IDs, so the
+	// new one is still added to it; the row itself is loaded on read.
+	id := cr.id(v)
+	key := cr.cfg.Keys.ID(id)
+	pipe := cr.cache.Pipeline()
+	pipe.Del(key)
+	pipe.SAddExisting(cr.cfg.Keys.Index(), strconv.FormatUint(uint64(id), 10))
+	_ = pipe.Exec(ctx)
+	cr.dropL1(key)
+	cr.invalidate(ctx, key)
+	return nil
+}
+
+func (cr *Cached[T]) updateWriteAround(ctx context.Context, v *T) error {
+	if err := cr.updateRow(ctx, v); err != nil {
+		return err
+	}
+
+	key := cr.cfg.Keys.ID(cr.id(v))
+	cr.drop(ctx, key)
+	cr.invalidate(ctx, key)
+	return nil
+}
@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Count returns the number of rows in the database, to report warm-up
+// progress against.
+func (cr *Cached[T]) Count(ctx context.Context) (int64, error) {
+	var total int64
+	err := cr.db.WithContext(ctx).Model(new(T)).Count(&total).Error
+	return total, err
+}
+
+// WarmUp loads every row into the cache before traffic arrives. Rows are
+// streamed from the database batchSize at a time and each batch is written
+// in a single pipeline, instead of one round trip per command as in a cold
+// List. The index is rebuilt once every row is cached.
+//
+// progress, if not nil, is called after each batch with the number of rows
+// loaded so far.
+func (cr *Cached[T]) WarmUp(ctx context.Context, batchSize int, progress func(loaded int)) (int, error) {
+	var batch []T
+	var ids []string
+	loaded := 0
+
+	err := cr.db.WithContext(ctx).Order("id").FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		pipe := cr.cache.Pipeline()
+		for i := range batch {
+			cr.queueEntry(pipe, &batch[i])
+			ids = append(ids, strconv.FormatUint(uint64(cr.id(&batch[i])), 10))
+		}
+		if err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		loaded += len(batch)
+		if progress != nil {
+			progress(loaded)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return loaded, err
+	}
+
+	pipe := cr.cache.Pipeline()
+	cr.queueIndex(pipe, ids)
+	return loaded, pipe.Exec(ctx)
+}
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/writebehind"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- STRATEGY: WRITE-THROUGH ---
+// Used for Create: Save to DB FIRST, then Cache.
+// Caching the new row also replaces any "not found" tombstone for its ID.
+// The other create strategies are in cached_writebehind.go and
+// cached_strategy.go.
+//
+// Only write-behind creates take their ID from the IDSeq sequence; the
+// others get an AUTO_INCREMENT, so they don't depend on Redis being up.
+func (cr *Cached[T]) Create(ctx context.Context, v *T) error {
+	cr.setVersion(v, 1)
+	switch cr.writeStrategy(strategy.OpCreate) {
+	case strategy.WriteBehind:
+		id, err := cr.nextID(ctx)
+		if err != nil {
+			return err
+		}
+		*cr.cfg.ID(v) = id
+		return cr.writeBehind(ctx, writebehind.OpCreate, v)
+	case strategy.WriteAround, strategy.NoCache:
+		return cr.createWriteAround(ctx, v)
+	}
+
+	err := cr.db.WithContext(ctx).Create(v).Error
+	if err != nil {
+		return err
+	}
+
+	// 2. Immediate Cache Update
+	key := cr.cfg.Keys.ID(cr.id(v))
+	pipe := cr.cache.Pipeline()
+	cr.queueEntry(pipe, v)
+	_ = pipe.Exec(ctx)
+	cr.setL1(key, v)
+	cr.publish(events.Write, key, "create")
+	cr.invalidate(ctx, key)
+
+	return nil
+}
+
+// lock takes the lock on the row with id. Only one request writes a row at
+// a time, the others wait their turn. The returned func releases it.
+func (cr *Cached[T]) lock(ctx context.Context, id uint) (*lock.Lock, func(), error) {
+	lockKey := cr.cfg.Keys.Lock(id)
+	lk, err := cr.cfg.Locks.Acquire(ctx, lockKey)
+	if errors.Is(err, lock.ErrNotAcquired) {
+		cr.publish(events.Lock, lockKey, "timeout")
+		return nil, nil, cr.cfg.Locked
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	cr.publish(events.Lock, lockKey, "acquired")
+	return lk, func() {
+		lk.Release(context.WithoutCancel(ctx))
+		cr.publish(events.Lock, lockKey, "released")
+	}, nil
+}
+
+// Update writes v to the database and the cache with the update strategy.
+// For a versioned model a non-zero version must match the stored one, and
+// v gets the new version.
+func (cr *Cached[T]) Update(ctx context.Context, v *T) error {
+	id := cr.id(v)
+	lk, release, err := cr.lock(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch cr.writeStrategy(strategy.OpUpdate) {
+	case strategy.WriteBehind:
+		if err := cr.nextVersion(ctx, v); err != nil {
+			return err
+		}
+		return cr.writeBehind(ctx, writebehind.OpUpdate, v)
+	case strategy.WriteAround, strategy.NoCache:
+		return cr.updateWriteAround(ctx, v)
+	}
+
+	// Update DB (source of truth)
+	if err := cr.updateRow(ctx, v); err != nil {
+		return err
+	}
+
+	// Update cache (write-through)
+	key := cr.cfg.Keys.ID(id)
+	select {
+	case <-lk.Lost():
+		// A newer update may hold the lock now and have cached its own
+		// value: drop the entry instead of overwriting it
+		cr.publish(events.Lock, cr.cfg.Keys.Lock(id), "lost")
+		cr.drop(ctx, key)
+	default:
+		pipe := cr.cache.Pipeline()
+		cr.queueEntry(pipe, v)
+		_ = pipe.Exec(ctx)
+		cr.setL1(key, v)
+		cr.publish(events.Write, key, "update")
+	}
+	cr.invalidate(ctx, key)
+
+	return nil
+}
+
+// Delete removes the row with id, then its hash (replaced by a tombstone
+// when negative caching is on) and its ID in the index. Deletes are always
+// written through, whatever the update strategy: a delete queued behind
+// the cache would leave a window in which reads still find the row.
+func (cr *Cached[T]) Delete(ctx context.Context, id uint) error {
+	_, release, err := cr.lock(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	res := cr.db.WithContext(ctx).Delete(new(T), id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return cr.cfg.NotFound
+	}
+
+	key := cr.cfg.Keys.ID(id)
+	pipe := cr.cache.Pipeline()
+	if !cr.queueTombstone(pipe, key) {
+		pipe.Del(key)
+	}
+	pipe.SRem(cr.cfg.Keys.Index(), strconv.FormatUint(uint64(id), 10))
+	_ = pipe.Exec(ctx)
+	cr.dropL1(key)
+	cr.publish(events.Write, key, "delete")
+	cr.invalidate(ctx, key)
+	return nil
+}
+
+// updateRow writes v to the database. For a versioned model it also bumps
+// the version: a non-zero version in v must match the stored version
+// (optimistic concurrency), 0 overwrites whatever is stored, and v gets the
+// new one.
+func (cr *Cached[T]) updateRow(ctx context.Context, v *T) error {
+	id := cr.id(v)
+	column := "id"
+	if cr.cfg.Version != nil {
+		column = versionField
+	}
+	return cr.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current []uint64
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Model(new(T)).
+			Where("id = ?", id).
+			Pluck(column, &current).Error
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return cr.cfg.NotFound
+		}
+
+		expected := cr.version(v)
+		if cr.cfg.Version != nil {
+			if err := cr.checkVersion(expected, current[0]); err != nil {
+				return err
+			}
+			cr.setVersion(v, current[0]+1)
+		}
+
+		// Select("*") writes zero values too, like a full replace
+		err = tx.Model(v).Select("*").Omit("id").Updates(v).Error
+		if err != nil {
+			cr.setVersion(v, expected)
+			return err
+		}
+		return nil
+	})
+}
+
+// checkVersion returns the Conflict error unless expected is 0 (no
+// precondition) or current.
+func (cr *Cached[T]) checkVersion(expected, current uint64) error {
+	if expected != 0 && expected != current {
+		return fmt.Errorf("%w: it is at version %d, not %d", cr.cfg.Conflict, current, expected)
+	}
+	return nil
+}
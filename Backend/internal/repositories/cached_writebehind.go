@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wailman24/Caching.git/internal/writebehind"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"gorm.io/gorm"
+)
+
+// --- STRATEGY: WRITE-BEHIND ---
+// The cache is written first and becomes the source of truth until the
+// mutation, appended to a Redis Stream, is flushed to the database in the
+// background. Writes are as fast as the cache, at the cost of a window in
+// which the database is behind (and a unique constraint violation is only
+// detected at flush time, landing the mutation in the dead-letter stream).
+func (cr *Cached[T]) writeBehind(ctx context.Context, op string, v *T) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	id := cr.id(v)
+	key := cr.cfg.Keys.ID(id)
+	pipe := cr.cache.Pipeline()
+	cr.queueEntry(pipe, v)
+	if err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	err = cr.cfg.Queue.Enqueue(ctx, writebehind.Mutation{
+		Entity:  cr.cfg.Entity,
+		Op:      op,
+		ID:      id,
+		Payload: payload,
+	})
+	if err != nil {
+		// Without the mutation the cached value would never reach the
+		// database
+		cr.drop(ctx, key)
+		cr.invalidate(ctx, key)
+		return err
+	}
+
+	cr.setL1(key, v)
+	cr.publish(events.Write, key, op+" (write-behind)")
+	cr.invalidate(ctx, key)
+	return nil
+}
+
+// nextVersion checks the version of v against the current version, like
+// updateRow, and sets it to the next one. While mutations are queued the
+// cache is ahead of the database, so the cached version wins. A model
+// without versions only has to exist.
+func (cr *Cached[T]) nextVersion(ctx context.Context, v *T) error {
+	id := cr.id(v)
+
+	var current uint64
+	if cached, ok := cr.readCached(ctx, cr.cfg.Keys.ID(id)); ok && cached.value != nil && cr.version(cached.value) > 0 {
+		current = cr.version(cached.value)
+	} else {
+		column := "id"
+		if cr.cfg.Version != nil {
+			column = versionField
+		}
+		var row []uint64
+		err := cr.db.WithContext(ctx).Model(new(T)).Where("id = ?", id).Pluck(column, &row).Error
+		if err != nil {
+			return err
+		}
+		if len(row) == 0 {
+			return cr.cfg.NotFound
+		}
+		if cr.cfg.Version == nil {
+			return nil
+		}
+		current = row[0]
+	}
+
+	if err := cr.checkVersion(cr.version(v), current); err != nil {
+		return err
+	}
+	cr.setVersion(v, current+1)
+	return nil
+}
+
+// nextID allocates an ID from the IDSeq sequence, raised to the highest ID
+// in the database first, since a write-behind row doesn't exist yet to get
+// an AUTO_INCREMENT.
+func (cr *Cached[T]) nextID(ctx context.Context) (uint, error) {
+	if cr.cfg.IDSeq == "" {
+		return 0, fmt.Errorf("%s has no ID sequence for write-behind creates", cr.cfg.Entity)
+	}
+	return cr.cfg.Queue.NextID(ctx, cr.cfg.IDSeq, func(ctx context.Context) (uint, error) {
+		var max uint
+		err := cr.db.WithContext(ctx).Model(new(T)).Select("COALESCE(MAX(id), 0)").Scan(&max).Error
+		return max, err
+	})
+}
+
+// ApplyMutation returns the writebehind.Applier of a model, given the
+// ID and Version pointers of its CachedConfig: creates insert the row, and
+// updates replace every column but the ID.
+func ApplyMutation[T any](id func(v *T) *uint, version func(v *T) *uint64) writebehind.Applier {
+	return func(tx *gorm.DB, m writebehind.Mutation) error {
+		var v T
+		if err := json.Unmarshal(m.Payload, &v); err != nil {
+			return err
+		}
+		*id(&v) = m.ID
+
+		switch m.Op {
+		case writebehind.OpCreate:
+			return tx.Create(&v).Error
+		case writebehind.OpUpdate:
+			var ver uint64
+			q := tx.Model(new(T)).Where("id = ?", m.ID).Select("*").Omit("id")
+			if version != nil {
+				if ver = *version(&v); ver > 0 {
+					// A redelivered older mutation must not roll the row back
+					q = q.Where(versionField+" < ?", ver)
+				} else {
+					// Mutations queued before the model had versions carry none
+					q = q.Omit("id", versionField)
+				}
+			}
+			res := q.Updates(&v)
+			if res.Error != nil || res.RowsAffected > 0 {
+				return res.Error
+			}
+			return updateApplied[T](tx, m, ver)
+		default:
+			return fmt.Errorf("unknown %s mutation %q", m.Entity, m.Op)
+		}
+	}
+}
+
+// updateApplied tells why an update matched no row. It was already applied
+// if the row is at its version or newer (or, without a version, unchanged).
+// If the row doesn't exist, the create is still queued, failed and pending,
+// or being flushed by another instance: the update is retried later rather
+// than acknowledged and lost.
+func updateApplied[T any](tx *gorm.DB, m writebehind.Mutation, ver uint64) error {
+	column := "id"
+	if ver > 0 {
+		column = versionField
+	}
+	var row []uint64
+	if err := tx.Model(new(T)).Where("id = ?", m.ID).Pluck(column, &row).Error; err != nil {
+		return err
+	}
+	if len(row) == 0 {
+		return fmt.Errorf("%s %d: %w", m.Entity, m.ID, writebehind.ErrNotCreated)
+	}
+	if ver > 0 && row[0] < ver {
+		return fmt.Errorf("%s %d: update to version %d matched no row at version %d", m.Entity, m.ID, ver, row[0])
+	}
+	return nil
+}
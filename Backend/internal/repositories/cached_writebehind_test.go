@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/writebehind"
+)
+
+func mutation(t *testing.T, op string, p models.Product) writebehind.Mutation {
+	t.Helper()
+	payload, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writebehind.Mutation{Entity: ProductEntity, Op: op, ID: p.ID, Payload: payload}
+}
+
+func TestApplyMutationUpdateBeforeCreate(t *testing.T) {
+	db := newTestDB(t)
+	create := mutation(t, writebehind.OpCreate, models.Product{ID: 5, Name: "keyboard", Price: "40", Version: 1})
+	update := mutation(t, writebehind.OpUpdate, models.Product{ID: 5, Name: "keyboard", Price: "50", Version: 2})
+
+	// The update is flushed first: it stays pending instead of being acked
+	if err := ApplyProductMutation(db, update); !errors.Is(err, writebehind.ErrNotCreated) {
+		t.Fatalf("update before create = %v, want ErrNotCreated", err)
+	}
+
+	if err := ApplyProductMutation(db, create); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyProductMutation(db, update); err != nil {
+		t.Fatalf("retried update = %v", err)
+	}
+
+	// A redelivered update is already applied and doesn't roll the row back
+	old := mutation(t, writebehind.OpUpdate, models.Product{ID: 5, Name: "keyboard", Price: "45", Version: 2})
+	if err := ApplyProductMutation(db, old); err != nil {
+		t.Errorf("redelivered update = %v", err)
+	}
+
+	var got models.Product
+	db.First(&got, 5)
+	if got.Price != "50" || got.Version != 2 {
+		t.Errorf("row = %+v, want version 2 at 50", got)
+	}
+}
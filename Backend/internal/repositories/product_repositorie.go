@@ -3,137 +3,277 @@ package repositories
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strconv"
 	"time"
+	"unsafe"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/writebehind"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/codec"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
 	"gorm.io/gorm"
 )
 
+// ProductRepositorie is the product catalog: a Cached repository of
+// models.Product under the product keys, returning the product errors.
 type ProductRepositorie struct {
-	db    *gorm.DB
-	cache *redis.Client
+	*Cached[models.Product]
 }
 
-func NewProductRepositorie(db *gorm.DB, cache *redis.Client) *ProductRepositorie {
-	return &ProductRepositorie{db: db, cache: cache}
+// ProductEntity names products in strategies, metrics, events and the
+// write-behind stream.
+const ProductEntity = "product"
+
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrVersionConflict is returned when an update expected another version
+// than the stored one: the product changed since the client read it.
+var ErrVersionConflict = errors.New("product was modified since it was read")
+
+// ErrProductLocked is returned when an update waited too long for the
+// product lock.
+var ErrProductLocked = errors.New("product is being updated, try again")
+
+// ProductSchemaVersion is the version in the product keys. Bump it when the
+// layout of the product hash changes, so a deploy reads fresh keys instead
+// of the hashes of the previous release, and say in ProductKeyMigrations
+// what becomes of those. Switching the codec.Default format or compression
+// doesn't need a bump, every value names its own.
+//
+// Version 1 held the fields id, name and price; version 2 holds the
+// product encoded by codec.Default.
+const ProductSchemaVersion = 2
+
+// ProductKeys returns the product keys of the current namespace and schema.
+func ProductKeys() keys.Family {
+	return keys.Default.Family(ProductEntity, ProductSchemaVersion)
 }
 
-// --- STRATEGY: WRITE-THROUGH ---
-// Used for Create: Save to DB FIRST, then Cache.
-func (pr *ProductRepositorie) CreateProduct(ctx context.Context, product *models.Product) error {
+// legacyProductKeys are the keys used before keys had a namespace and a
+// version. Their hashes have the fields of version 1.
+var legacyProductKeys = keys.Unversioned(ProductEntity, "product:", "products:all_ids")
 
-	err := pr.db.WithContext(ctx).Create(product).Error
-	if err != nil {
-		return err
+// ProductKeyMigrations moves the product keys of earlier schemas to the
+// current one: hashes of the legacy keys and of version 1 are re-encoded
+// with codec.Default.
+func ProductKeyMigrations() []keys.Migration {
+	current := ProductKeys()
+	return []keys.Migration{
+		{From: legacyProductKeys, To: current, Transform: encodeProductV1},
+		{From: current.WithVersion(1), To: current, Transform: encodeProductV1},
 	}
+}
 
-	// 2. Immediate Cache Update
-	pKey := fmt.Sprintf("product:%d", product.ID)
-	pr.cache.HSet(ctx, pKey, "id", product.ID,
-		"name", product.Name,
-		"price", product.Price)
-	pr.cache.SAdd(ctx, "products:all_ids", product.ID)
+// encodeProductV1 converts a version 1 hash to version 2, keeping its
+// version and expiry. Tombstones are the same in both.
+func encodeProductV1(h map[string]string) map[string]string {
+	if h[tombstoneField] != "" {
+		return h
+	}
+	id, err := strconv.ParseUint(h["id"], 10, 64)
+	if err != nil || id == 0 {
+		return nil
+	}
+	version, _ := strconv.ParseUint(h[versionField], 10, 64)
+	data, err := codec.Default.Encode(&models.Product{
+		ID:      uint(id),
+		Name:    h["name"],
+		Price:   h["price"],
+		Version: version,
+	})
+	if err != nil {
+		return nil
+	}
+	out := map[string]string{valueField: string(data)}
+	for _, field := range []string{versionField, expiresAtField} {
+		if v, ok := h[field]; ok {
+			out[field] = v
+		}
+	}
+	return out
+}
 
-	return nil
+// ProductStrategies returns the product strategies used until they are
+// changed through the registry: read-through reads, and write for creates
+// and updates (write-through or write-behind).
+func ProductStrategies(write strategy.Strategy) map[strategy.Operation]strategy.Strategy {
+	return map[strategy.Operation]strategy.Strategy{
+		strategy.OpGet:    strategy.ReadThrough,
+		strategy.OpList:   strategy.ReadThrough,
+		strategy.OpCreate: write,
+		strategy.OpUpdate: write,
+	}
 }
 
-// 2. GET All (Cache-Aside)
-func (pr *ProductRepositorie) GetAllProducts(ctx context.Context) ([]models.Product, error) {
-	var products []models.Product
-
-	// Get IDs from the Redis Index
-	ids, _ := pr.cache.SMembers(ctx, "products:all_ids").Result()
-	if len(ids) == 0 {
-		// Cache MISS for the set
-		fmt.Println("Cache MISS: products:all_ids")
-
-		var products []models.Product
-		pr.db.WithContext(ctx).Find(&products)
-		for _, p := range products {
-			pKey := fmt.Sprintf("product:%d", p.ID)
-			pr.cache.HSet(ctx, pKey,
-				"id", p.ID,
-				"name", p.Name,
-				"price", p.Price,
-			)
-			pr.cache.SAdd(ctx, "products:all_ids", p.ID)
+// ApplyProductMutation writes a product mutation from the write-behind
+// stream to the database.
+var ApplyProductMutation = ApplyMutation(productID, productVersion)
+
+// EvictDeadProduct drops the cached copy of a product whose write-behind
+// mutation was dead-lettered, so readers go back to what MySQL holds. The
+// bus evicts it from the L1 of every instance, this one included; it may be
+// nil.
+func EvictDeadProduct(c cache.Cache, bus *invalidation.Bus) func(ctx context.Context, m writebehind.Mutation) {
+	return func(ctx context.Context, m writebehind.Mutation) {
+		if m.Entity == ProductEntity {
+			pKey := ProductKeys().ID(m.ID)
+			c.Del(ctx, pKey)
+			_ = bus.Evict(ctx, pKey)
 		}
-		return products, nil
 	}
-	//Cache HIT for the set
-	fmt.Println("Cache HIT: products:all_ids")
+}
 
-	for _, idStr := range ids {
-		id, _ := strconv.Atoi(idStr)
-		// Re-use GetByID to benefit from caching per item
-		if p, err := pr.GetProductByID(ctx, uint(id)); err == nil {
-			products = append(products, *p)
-		}
+type ProductOption func(*CachedConfig[models.Product])
+
+// WithTTLs sets the expiry of product keys. Without it keys never expire.
+func WithTTLs(ttls CacheTTLs) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.TTLs = ttls
 	}
+}
 
-	return products, nil
+// WithFillLease makes cache misses coordinate across instances: the first
+// instance to miss takes a short Redis lease on the key and the others wait
+// for it to refill the cache instead of all querying MySQL.
+func WithFillLease(ttl time.Duration) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.FillLease = ttl
+	}
 }
 
-// 2. GET BY ID (Cache-Aside)
-func (pr *ProductRepositorie) GetProductByID(ctx context.Context, id uint) (*models.Product, error) {
-	var product models.Product
-	pKey := fmt.Sprintf("product:%d", id)
+// WithWriteBehind makes the write-behind strategy available, queueing
+// database writes on q. Without a strategy registry it is also used for
+// every create and update.
+func WithWriteBehind(q *writebehind.Queue) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Queue = q
+	}
+}
+
+// WithStrategies picks the strategy of each operation from reg at call
+// time, so it can be switched without a restart.
+func WithStrategies(reg *strategy.Registry) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Strategies = reg
+	}
+}
 
-	// Check Redis Hash
-	err := pr.cache.HGetAll(ctx, pKey).Scan(&product)
+// WithL1 puts an in-process cache in front of the shared cache for reads.
+// Writes update it as well (write-through), so this node never serves its
+// own stale data.
+func WithL1(l1 *local.Cache[models.Product]) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.L1 = l1
+	}
+}
 
-	// Redis HGetAll returns an empty map if not found, Scan might not error
-	if err == nil && product.ID != 0 {
-		fmt.Println("Cache HIT for product:", id)
-		return &product, nil
+// WithInvalidation publishes every product write on bus, and evicts the
+// products other instances write from L1.
+func WithInvalidation(bus *invalidation.Bus) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Bus = bus
 	}
+}
 
-	fmt.Println("Cache MISS for product:", id)
-	err = pr.db.WithContext(ctx).First(&product, id).Error
-	if err != nil {
-		return nil, err
+// WithTracking evicts from L1 the products Redis reports as changed through
+// client tracking, including writes made outside this application.
+func WithTracking(t *cache.Tracker) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Tracker = t
 	}
+}
 
-	// Refill Cache
-	pr.cache.HSet(ctx, pKey, "id", product.ID,
-		"name", product.Name,
-		"price", product.Price)
-	_, _ = pr.cache.SAdd(ctx, "products:all_ids", product.ID).Result()
-	return &product, nil
+// WithKeyspace publishes the product hashes Redis evicts, expires or
+// deletes, and removes the IDs of deleted products from the index.
+func WithKeyspace(l *cache.KeyspaceListener) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Keyspace = l
+	}
+}
+
+// WithEvents publishes the hits, misses, writes, invalidations and locks
+// of the repository on bus, for the live event stream.
+func WithEvents(bus *events.Bus) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Events = bus
+	}
 }
 
+// WithLocks sets the locker serializing updates, e.g. a Redlock. By default
+// locks are kept in the repository's cache.
+func WithLocks(l *lock.Locker) ProductOption {
+	return func(cfg *CachedConfig[models.Product]) {
+		cfg.Locks = l
+	}
+}
+
+func NewProductRepositorie(db *gorm.DB, cache cache.Cache, opts ...ProductOption) *ProductRepositorie {
+	cfg := CachedConfig[models.Product]{
+		Entity:   ProductEntity,
+		Keys:     ProductKeys(),
+		Codec:    Encoded(codec.Default, productID),
+		ID:       productID,
+		Version:  productVersion,
+		NotFound: ErrProductNotFound,
+		Locked:   ErrProductLocked,
+		Conflict: ErrVersionConflict,
+		IDSeq:    ProductKeys().IDSeq(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &ProductRepositorie{Cached: NewCached(db, cache, cfg)}
+}
+
+func productID(p *models.Product) *uint {
+	return &p.ID
+}
+
+func productVersion(p *models.Product) *uint64 {
+	return &p.Version
+}
+
+// ProductSize estimates the bytes a product takes in the L1 cache.
+func ProductSize(key string, p models.Product) int64 {
+	return int64(len(key) + len(p.Name) + len(p.Price) + int(unsafe.Sizeof(p)))
+}
+
+func (pr *ProductRepositorie) CreateProduct(ctx context.Context, product *models.Product) error {
+	return pr.Create(ctx, product)
+}
+
+func (pr *ProductRepositorie) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	return pr.List(ctx)
+}
+
+func (pr *ProductRepositorie) GetProductByID(ctx context.Context, id uint) (*models.Product, error) {
+	return pr.GetByID(ctx, id)
+}
+
+// UpdateProduct replaces a product. A non-zero product.Version must match
+// the stored version, and product.Version is set to the new one.
 func (pr *ProductRepositorie) UpdateProduct(ctx context.Context, product *models.Product) error {
-	lockKey := fmt.Sprintf("lock:product:%d", product.ID)
-
-	// Acquire lock
-	// Ensure only one request updates this product at a time
-	ok, err := pr.cache.SetNX(ctx, lockKey, "1", 5*time.Second).Result()
-	if err != nil || !ok {
-		return errors.New("product is being updated, try again")
-	}
-	defer pr.cache.Del(ctx, lockKey)
-
-	// Update DB (source of truth)
-	if err := pr.db.WithContext(ctx).
-		Model(&models.Product{}).
-		Where("id = ?", product.ID).
-		Updates(map[string]interface{}{
-			"name":  product.Name,
-			"price": product.Price,
-		}).Error; err != nil {
-		return err
-	}
-
-	// Update cache (write-through)
-	pKey := fmt.Sprintf("product:%d", product.ID)
-	pr.cache.HSet(ctx, pKey,
-		"id", product.ID,
-		"name", product.Name,
-		"price", product.Price,
-	)
-
-	return nil
+	return pr.Update(ctx, product)
+}
+
+func (pr *ProductRepositorie) DeleteProduct(ctx context.Context, id uint) error {
+	return pr.Delete(ctx, id)
+}
+
+// GetProductTTL reports the remaining TTL of a product hash and of the ID
+// index, for debugging expiry settings.
+func (pr *ProductRepositorie) GetProductTTL(ctx context.Context, id uint) ([]cache.KeyTTL, error) {
+	return pr.GetTTL(ctx, id)
+}
+
+// CountProducts returns the number of products in MySQL, to report warm-up
+// progress against.
+func (pr *ProductRepositorie) CountProducts(ctx context.Context) (int64, error) {
+	return pr.Count(ctx)
 }
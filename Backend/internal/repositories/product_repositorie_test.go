@@ -0,0 +1,213 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens an in-memory SQLite database with the product table,
+// private to the test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Product{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func newTestProducts(t *testing.T) (*ProductRepositorie, *gorm.DB, *cache.MemoryCache) {
+	t.Helper()
+	db := newTestDB(t)
+	mc := cache.NewMemoryCache()
+	pr := NewProductRepositorie(db, mc, WithTTLs(CacheTTLs{
+		Entry:   cache.TTLPolicy{Base: time.Hour},
+		Index:   cache.TTLPolicy{Base: time.Hour},
+		Missing: cache.TTLPolicy{Base: time.Minute},
+	}))
+	return pr, db, mc
+}
+
+func TestProductRepositorieFlow(t *testing.T) {
+	ctx := context.Background()
+	pr, db, mc := newTestProducts(t)
+	keys := ProductKeys()
+
+	// Create writes the row and its hash
+	p := &models.Product{Name: "keyboard", Price: "40"}
+	if err := pr.CreateProduct(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.ID == 0 || p.Version != 1 {
+		t.Fatalf("created %+v, want an ID and version 1", p)
+	}
+	if h, _ := mc.HGetAll(ctx, keys.ID(p.ID)); h[valueField] == "" {
+		t.Errorf("hash after create = %v, want the encoded product", h)
+	}
+
+	// Get is served from the cache, even once the row changed behind it
+	db.Model(&models.Product{}).Where("id = ?", p.ID).Update("price", "99")
+	got, err := pr.GetProductByID(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "keyboard" || got.Price != "40" {
+		t.Errorf("get = %+v, want the cached product", got)
+	}
+
+	// A miss reads MySQL and fills the cache
+	mc.Del(ctx, keys.ID(p.ID))
+	got, err = pr.GetProductByID(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Price != "99" {
+		t.Errorf("get after miss = %+v, want the row", got)
+	}
+	if h, _ := mc.HGetAll(ctx, keys.ID(p.ID)); h[valueField] == "" {
+		t.Error("miss didn't fill the cache")
+	}
+
+	// Update bumps the version and rewrites the hash
+	upd := &models.Product{ID: p.ID, Name: "keyboard", Price: "50", Version: 1}
+	if err := pr.UpdateProduct(ctx, upd); err != nil {
+		t.Fatal(err)
+	}
+	if upd.Version != 2 {
+		t.Errorf("version after update = %d, want 2", upd.Version)
+	}
+	got, _ = pr.GetProductByID(ctx, p.ID)
+	if got.Price != "50" || got.Version != 2 {
+		t.Errorf("get after update = %+v", got)
+	}
+
+	// An update from a stale read is refused
+	stale := &models.Product{ID: p.ID, Name: "keyboard", Price: "1", Version: 1}
+	if err := pr.UpdateProduct(ctx, stale); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("stale update = %v, want ErrVersionConflict", err)
+	}
+
+	// List reads every product and builds the index
+	p2 := &models.Product{Name: "mouse", Price: "20"}
+	if err := pr.CreateProduct(ctx, p2); err != nil {
+		t.Fatal(err)
+	}
+	list, err := pr.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("list = %+v, want 2 products", list)
+	}
+	if ids, _ := mc.SMembers(ctx, keys.Index()); len(ids) != 2 {
+		t.Errorf("index = %v, want 2 IDs", ids)
+	}
+
+	// Delete removes the row and leaves a tombstone
+	if err := pr.DeleteProduct(ctx, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := mc.HGetAll(ctx, keys.ID(p.ID)); h[tombstoneField] == "" {
+		t.Errorf("hash after delete = %v, want a tombstone", h)
+	}
+	if _, err := pr.GetProductByID(ctx, p.ID); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("get after delete = %v, want ErrProductNotFound", err)
+	}
+	list, _ = pr.GetAllProducts(ctx)
+	if len(list) != 1 || list[0].ID != p2.ID {
+		t.Errorf("list after delete = %+v, want only %d", list, p2.ID)
+	}
+}
+
+func TestProductRepositorieTombstone(t *testing.T) {
+	ctx := context.Background()
+	pr, db, mc := newTestProducts(t)
+	key := ProductKeys().ID(42)
+
+	if _, err := pr.GetProductByID(ctx, 42); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("get = %v, want ErrProductNotFound", err)
+	}
+	if h, _ := mc.HGetAll(ctx, key); h[tombstoneField] == "" {
+		t.Fatalf("hash after miss = %v, want a tombstone", h)
+	}
+	if ttl, _ := mc.TTL(ctx, key); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("tombstone TTL = %v, want the Missing TTL", ttl)
+	}
+
+	// The tombstone answers until it expires or the row is written through
+	// the repository
+	db.Create(&models.Product{ID: 42, Name: "ghost", Price: "1", Version: 1})
+	if _, err := pr.GetProductByID(ctx, 42); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("get with tombstone = %v, want ErrProductNotFound", err)
+	}
+
+	upd := &models.Product{ID: 42, Name: "ghost", Price: "2"}
+	if err := pr.UpdateProduct(ctx, upd); err != nil {
+		t.Fatal(err)
+	}
+	got, err := pr.GetProductByID(ctx, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Price != "2" {
+		t.Errorf("get after update = %+v", got)
+	}
+}
+
+func TestProductRefillDoesNotOverwriteNewerVersion(t *testing.T) {
+	ctx := context.Background()
+	pr, _, mc := newTestProducts(t)
+
+	p := &models.Product{Name: "keyboard", Price: "40"}
+	if err := pr.CreateProduct(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	// A reader loads version 1 from MySQL, then an update caches version 2
+	// before the reader refills the cache
+	stale := *p
+	upd := &models.Product{ID: p.ID, Name: "keyboard", Price: "50", Version: 1}
+	if err := pr.UpdateProduct(ctx, upd); err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := mc.Pipeline()
+	pr.queueEntry(pipe, &stale)
+	if err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := pr.GetProductByID(ctx, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 || got.Price != "50" {
+		t.Errorf("cached %+v after a stale refill, want version 2", got)
+	}
+
+	// A refill of the same or a newer version does replace it
+	newer := models.Product{ID: p.ID, Name: "keyboard", Price: "60", Version: 3}
+	pipe = mc.Pipeline()
+	pr.queueEntry(pipe, &newer)
+	pipe.Exec(ctx)
+	if got, _ := pr.GetProductByID(ctx, p.ID); got.Version != 3 {
+		t.Errorf("cached %+v, want version 3", got)
+	}
+}
@@ -3,17 +3,88 @@ package repositories
 import (
 	"context"
 	"errors"
+	"strconv"
 
 	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
 	"gorm.io/gorm"
 )
 
 type UserRepositorie struct {
 	db *gorm.DB
+	// users is nil unless lookups are cached, see WithUserCache
+	users *Cached[models.User]
 }
 
-func NewUserRepositorie(db *gorm.DB) *UserRepositorie {
-	return &UserRepositorie{db: db}
+// UserEntity names users in metrics and events.
+const UserEntity = "user"
+
+// UserSchemaVersion is the version in the user keys, see
+// ProductSchemaVersion.
+const UserSchemaVersion = 1
+
+// UserKeys returns the user keys of the current namespace and schema.
+func UserKeys() keys.Family {
+	return keys.Default.Family(UserEntity, UserSchemaVersion)
+}
+
+type UserOption func(*UserRepositorie)
+
+// WithUserCache caches users in c, with their ID under their email so a
+// login doesn't query MySQL. The hashes hold the password hashes, which is
+// why it is opt-in.
+func WithUserCache(c cache.Cache, ttls CacheTTLs) UserOption {
+	return func(ur *UserRepositorie) {
+		ur.users = NewCached(ur.db, c, CachedConfig[models.User]{
+			Entity: UserEntity,
+			Keys:   UserKeys(),
+			Codec:  userCodec{},
+			ID:     func(u *models.User) *uint { return &u.ID },
+			Lookups: map[string]func(u *models.User) string{
+				"email": func(u *models.User) string { return u.Email },
+			},
+			TTLs: ttls,
+		})
+	}
+}
+
+func NewUserRepositorie(db *gorm.DB, opts ...UserOption) *UserRepositorie {
+	ur := &UserRepositorie{db: db}
+	for _, opt := range opts {
+		opt(ur)
+	}
+	return ur
+}
+
+// Cache returns the cached repository behind the lookups, nil unless
+// WithUserCache was given.
+func (ur *UserRepositorie) Cache() *Cached[models.User] {
+	return ur.users
+}
+
+type userCodec struct{}
+
+func (userCodec) Encode(u *models.User) (map[string]string, error) {
+	return map[string]string{
+		"id":       strconv.FormatUint(uint64(u.ID), 10),
+		"name":     u.Name,
+		"email":    u.Email,
+		"password": u.Password,
+	}, nil
+}
+
+func (userCodec) Decode(h map[string]string) (*models.User, bool) {
+	id, err := strconv.ParseUint(h["id"], 10, 64)
+	if err != nil || id == 0 {
+		return nil, false
+	}
+	return &models.User{
+		ID:       uint(id),
+		Name:     h["name"],
+		Email:    h["email"],
+		Password: h["password"],
+	}, true
 }
 
 var ErrEmailAlreadyExists = errors.New("email already exists")
@@ -32,7 +103,11 @@ func (ur *UserRepositorie) CreateUser(ctx context.Context, user *models.User) er
 	}
 
 	// Email doesn't exist, create the user
-	err = ur.db.WithContext(ctx).Create(user).Error
+	if ur.users != nil {
+		err = ur.users.Create(ctx, user)
+	} else {
+		err = ur.db.WithContext(ctx).Create(user).Error
+	}
 	if err != nil {
 		// Check if it's a duplicate key error from database
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -46,8 +121,7 @@ func (ur *UserRepositorie) CreateUser(ctx context.Context, user *models.User) er
 
 func (ur *UserRepositorie) GetUserByEmail(ctx context.Context, user *models.UserLogin) (*models.UserLogin, error) {
 	// Get full user data including name
-	var fullUser models.User
-	err := ur.db.WithContext(ctx).Where("email = ?", user.Email).First(&fullUser).Error
+	fullUser, err := ur.getByEmail(ctx, user.Email)
 	if err != nil {
 		return nil, err
 	}
@@ -62,3 +136,15 @@ func (ur *UserRepositorie) GetUserByEmail(ctx context.Context, user *models.User
 
 	return result, nil
 }
+
+func (ur *UserRepositorie) getByEmail(ctx context.Context, email string) (*models.User, error) {
+	if ur.users != nil {
+		return ur.users.GetBy(ctx, "email", email)
+	}
+	var user models.User
+	err := ur.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
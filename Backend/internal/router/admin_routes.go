@@ -0,0 +1,34 @@
+package router
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/handlers"
+	"github.com/wailman24/Caching.git/internal/middlewares"
+	"github.com/wailman24/Caching.git/internal/reconcile"
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+)
+
+func AdminRoutes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(middlewares.AuthMiddleware)
+	r.Use(middlewares.AdminMiddleware)
+
+	h := handlers.NewStrategyHandler(strategy.Default)
+	r.Get("/strategies", h.GetStrategies)
+	r.Put("/strategies/{entity}/{operation}", h.SetStrategy)
+
+	rh := handlers.NewReconcileHandler(reconcile.Default)
+	r.Get("/reconcile", rh.GetReconcileStats)
+	r.Get("/reconcile/{entity}", rh.Reconcile)
+	r.Post("/reconcile/{entity}", rh.Repair)
+
+	// Key migrations need Redis
+	if keys.DefaultMigrator != nil {
+		kh := handlers.NewKeyMigrationHandler(keys.DefaultMigrator)
+		r.Get("/keys/migrations", kh.GetMigrationStats)
+		r.Get("/keys/migrate", kh.Preview)
+		r.Post("/keys/migrate", kh.Migrate)
+	}
+	return r
+}
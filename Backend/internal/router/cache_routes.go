@@ -0,0 +1,42 @@
+package router
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/handlers"
+	"github.com/wailman24/Caching.git/internal/middlewares"
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/internal/services"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+)
+
+// CacheRoutes serves the cache dashboard. cacheRepo holds the repositories
+// registered by the other route groups.
+func CacheRoutes(cacheRepo *repositories.CacheRepositorie) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		// The key names and server counters are for operators only
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminMiddleware)
+
+		cacheServ := services.NewCacheService(cacheRepo)
+		h := handlers.NewCacheHandler(cacheServ)
+		r.Get("/stats", h.GetStats)
+		r.Get("/keys", h.GetKeys)
+	})
+
+	if events.Default != nil {
+		r.Group(func(r chi.Router) {
+			// EventSource and WebSocket clients can't send headers. The
+			// events name the keys, so they are for operators only too
+			r.Use(middlewares.QueryTokenMiddleware)
+			r.Use(middlewares.AuthMiddleware)
+			r.Use(middlewares.AdminMiddleware)
+
+			eh := handlers.NewEventHandler(events.Default)
+			r.Get("/events", eh.Stream)
+			r.Get("/events/ws", eh.StreamWS)
+		})
+	}
+	return r
+}
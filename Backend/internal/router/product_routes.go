@@ -1,22 +1,121 @@
 package router
 
 import (
+	"log"
+	"time"
+
 	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/config"
 	"github.com/wailman24/Caching.git/internal/handlers"
+	"github.com/wailman24/Caching.git/internal/metrics"
+	"github.com/wailman24/Caching.git/internal/middlewares"
+	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/reconcile"
 	"github.com/wailman24/Caching.git/internal/repositories"
 	"github.com/wailman24/Caching.git/internal/services"
+	"github.com/wailman24/Caching.git/internal/strategy"
+	"github.com/wailman24/Caching.git/internal/writebehind"
 	"github.com/wailman24/Caching.git/pkg/cache"
+	"github.com/wailman24/Caching.git/pkg/cache/events"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+	"github.com/wailman24/Caching.git/pkg/cache/local"
+	"github.com/wailman24/Caching.git/pkg/cache/lock"
 	"github.com/wailman24/Caching.git/pkg/db"
 )
 
-func ProductRoutes() *chi.Mux {
+func ProductRoutes(cacheRepo *repositories.CacheRepositorie) *chi.Mux {
 	r := chi.NewRouter()
-	prodRepo := repositories.NewProductRepositorie(db.Db, cache.Rdb)
+	r.Use(middlewares.CacheTraceMiddleware)
+
+	opts := []repositories.ProductOption{
+		repositories.WithTTLs(ProductTTLs()),
+		// 0 keeps coalescing within this process only
+		repositories.WithFillLease(config.Duration("CACHE_FILL_LEASE", 0)),
+	}
+	if writebehind.Default != nil {
+		opts = append(opts, repositories.WithWriteBehind(writebehind.Default))
+	}
+	if strategy.Default != nil {
+		opts = append(opts, repositories.WithStrategies(strategy.Default))
+	}
+	if invalidation.Default != nil {
+		opts = append(opts, repositories.WithInvalidation(invalidation.Default))
+	}
+	if lock.Default != nil {
+		opts = append(opts, repositories.WithLocks(lock.Default))
+	}
+	if events.Default != nil {
+		opts = append(opts, repositories.WithEvents(events.Default))
+	}
+	if cache.Tracking != nil {
+		opts = append(opts, repositories.WithTracking(cache.Tracking))
+	}
+	if cache.Keyspace != nil {
+		opts = append(opts, repositories.WithKeyspace(cache.Keyspace))
+	}
+
+	// L1 is off unless a memory budget is configured
+	if maxBytes := config.Int("L1_CACHE_MAX_BYTES", 0); maxBytes > 0 {
+		l1, err := local.New(local.Options[models.Product]{
+			MaxBytes: int64(maxBytes),
+			TTL:      config.Duration("L1_CACHE_TTL", 30*time.Second),
+			Policy:   config.String("L1_CACHE_POLICY", local.PolicyLRU),
+			Sizer:    repositories.ProductSize,
+			OnEvict: func(key string) {
+				events.Default.Publish(events.Event{Type: events.Eviction, Entity: repositories.ProductEntity, Key: key, Detail: "l1"})
+			},
+		})
+		if err != nil {
+			log.Fatalf("Invalid L1 cache configuration: %v", err)
+		}
+		metrics.RegisterL1("product", l1.Stats)
+		opts = append(opts, repositories.WithL1(l1))
+	}
+
+	prodRepo := repositories.NewProductRepositorie(db.Db, cache.Store, opts...)
+	reconcile.Default.Register(repositories.ProductEntity, prodRepo.Reconcile)
+	cacheRepo.Register(repositories.ProductEntity, prodRepo)
 	prodServ := services.NewProductService(prodRepo)
-	h := handlers.NewProductHandler(prodServ)
+	h := handlers.NewProductHandler(prodServ,
+		handlers.WithRequireIfMatch(config.Bool("PRODUCT_REQUIRE_IF_MATCH", false)),
+	)
 	r.Get("/all", h.GetAllProducts)
 	r.Get("/getbyid/{id}", h.GetProductByID)
 	r.Post("/create", h.CreateProduct)
 	r.Put("/update", h.UpdateProduct)
+	r.Delete("/delete/{id}", h.DeleteProduct)
+
+	r.Group(func(r chi.Router) {
+		// Key TTLs and the queue, lock and invalidation counters are for
+		// operators only, like the cache dashboard
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminMiddleware)
+		r.Get("/ttl/{id}", h.GetProductTTL)
+		r.Get("/cache-stats", h.GetCacheStats)
+	})
 	return r
 }
+
+// ProductTTLs reads the expiry of the product key families from the
+// environment.
+func ProductTTLs() repositories.CacheTTLs {
+	return repositories.CacheTTLs{
+		Entry: cache.TTLPolicy{
+			Base:   config.Duration("PRODUCT_TTL", time.Hour),
+			Jitter: config.Duration("PRODUCT_TTL_JITTER", 5*time.Minute),
+			// refresh-ahead and stale-if-error are off by default
+			RefreshAhead: config.Duration("PRODUCT_REFRESH_AHEAD", 0),
+			StaleIfError: config.Duration("PRODUCT_STALE_IF_ERROR", 0),
+		},
+		Index: cache.TTLPolicy{
+			Base:         config.Duration("PRODUCT_INDEX_TTL", 10*time.Minute),
+			Jitter:       config.Duration("PRODUCT_INDEX_TTL_JITTER", time.Minute),
+			RefreshAhead: config.Duration("PRODUCT_INDEX_REFRESH_AHEAD", 0),
+			StaleIfError: config.Duration("PRODUCT_INDEX_STALE_IF_ERROR", 0),
+		},
+		Missing: cache.TTLPolicy{
+			Base:   config.Duration("PRODUCT_NOT_FOUND_TTL", 30*time.Second),
+			Jitter: config.Duration("PRODUCT_NOT_FOUND_TTL_JITTER", 5*time.Second),
+		},
+	}
+}
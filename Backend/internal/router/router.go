@@ -2,7 +2,12 @@ package router
 
 import (
 	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/handlers"
+	"github.com/wailman24/Caching.git/internal/health"
+	"github.com/wailman24/Caching.git/internal/metrics"
 	"github.com/wailman24/Caching.git/internal/middlewares"
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/pkg/cache"
 )
 
 func MainRoutes() *chi.Mux {
@@ -10,10 +15,20 @@ func MainRoutes() *chi.Mux {
 	
 	// Apply CORS middleware to all routes
 	apiroute.Use(middlewares.CORSMiddleware)
+	apiroute.Use(middlewares.MetricsMiddleware)
 	
+	hh := handlers.NewHealthHandler(health.Default)
+	apiroute.Get("/healthz", hh.Healthz)
+	apiroute.Get("/readyz", hh.Readyz)
+	apiroute.Handle("/metrics", metrics.Handler())
+
+	cacheRepo := repositories.NewCacheRepositorie(cache.Store)
+
 	apiroute.Route("/api", func(r chi.Router) {
-		r.Mount("/users", UserRoutes())
-		r.Mount("/products", ProductRoutes())
+		r.Mount("/users", UserRoutes(cacheRepo))
+		r.Mount("/products", ProductRoutes(cacheRepo))
+		r.Mount("/admin", AdminRoutes())
+		r.Mount("/cache", CacheRoutes(cacheRepo))
 
 	})
 
@@ -1,17 +1,30 @@
 package router
 
 import (
+	"time"
+
 	"github.com/go-chi/chi"
+	"github.com/wailman24/Caching.git/internal/config"
 	"github.com/wailman24/Caching.git/internal/handlers"
 	"github.com/wailman24/Caching.git/internal/middlewares"
 	"github.com/wailman24/Caching.git/internal/repositories"
 	"github.com/wailman24/Caching.git/internal/services"
+	"github.com/wailman24/Caching.git/pkg/cache"
 	"github.com/wailman24/Caching.git/pkg/db"
 )
 
-func UserRoutes() *chi.Mux {
+func UserRoutes(cacheRepo *repositories.CacheRepositorie) *chi.Mux {
 	r := chi.NewRouter()
-	userRepo := repositories.NewUserRepositorie(db.Db)
+
+	// Off by default: the cached hashes hold the password hashes
+	var opts []repositories.UserOption
+	if config.Bool("USER_CACHE", false) {
+		opts = append(opts, repositories.WithUserCache(cache.Store, UserTTLs()))
+	}
+	userRepo := repositories.NewUserRepositorie(db.Db, opts...)
+	if users := userRepo.Cache(); users != nil {
+		cacheRepo.Register(repositories.UserEntity, users)
+	}
 	userServ := services.NewUserService(userRepo)
 	h := handlers.NewUserHandler(userServ)
 	r.Post("/login", h.Login)
@@ -24,3 +37,14 @@ func UserRoutes() *chi.Mux {
 
 	return r
 }
+
+// UserTTLs reads the expiry of the user keys from the environment. Users
+// are only read one at a time, so they have no index.
+func UserTTLs() repositories.CacheTTLs {
+	return repositories.CacheTTLs{
+		Entry: cache.TTLPolicy{
+			Base:   config.Duration("USER_TTL", 10*time.Minute),
+			Jitter: config.Duration("USER_TTL_JITTER", time.Minute),
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+type CacheRepository interface {
+	Stats(ctx context.Context) (repositories.CacheOverview, error)
+	Keys(ctx context.Context, pattern string, limit int) ([]cache.KeyInfo, error)
+}
+
+type CacheService struct {
+	repo CacheRepository
+}
+
+func NewCacheService(repo CacheRepository) *CacheService {
+	return &CacheService{
+		repo: repo,
+	}
+}
+
+func (cs *CacheService) Stats(ctx context.Context) (repositories.CacheOverview, error) {
+	return cs.repo.Stats(ctx)
+}
+
+func (cs *CacheService) Keys(ctx context.Context, pattern string, limit int) ([]cache.KeyInfo, error) {
+	return cs.repo.Keys(ctx, pattern, limit)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/wailman24/Caching.git/internal/models"
+	"github.com/wailman24/Caching.git/internal/repositories"
+	"github.com/wailman24/Caching.git/pkg/cache"
 )
 
 type ProductRepository interface {
@@ -11,6 +13,9 @@ type ProductRepository interface {
 	CreateProduct(ctx context.Context, product *models.Product) error
 	GetProductByID(ctx context.Context, id uint) (*models.Product, error)
 	UpdateProduct(ctx context.Context, product *models.Product) error
+	DeleteProduct(ctx context.Context, id uint) error
+	GetProductTTL(ctx context.Context, id uint) ([]cache.KeyTTL, error)
+	Stats(ctx context.Context) repositories.EntityCacheStats
 }
 
 type ProductService struct {
@@ -38,3 +43,15 @@ func (ps *ProductService) GetProductByID(ctx context.Context, id uint) (*models.
 func (ps *ProductService) UpdateProduct(ctx context.Context, product *models.Product) error {
 	return ps.repo.UpdateProduct(ctx, product)
 }
+
+func (ps *ProductService) DeleteProduct(ctx context.Context, id uint) error {
+	return ps.repo.DeleteProduct(ctx, id)
+}
+
+func (ps *ProductService) GetProductTTL(ctx context.Context, id uint) ([]cache.KeyTTL, error) {
+	return ps.repo.GetProductTTL(ctx, id)
+}
+
+func (ps *ProductService) Stats(ctx context.Context) repositories.EntityCacheStats {
+	return ps.repo.Stats(ctx)
+}
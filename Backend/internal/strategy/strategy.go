@@ -0,0 +1,247 @@
+// Package strategy keeps the caching strategy used by each entity and
+// operation. Strategies can be switched at runtime and are persisted in the
+// database so they survive restarts.
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wailman24/Caching.git/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Strategy string
+
+const (
+	// reads
+	CacheAside  Strategy = "cache-aside"
+	ReadThrough Strategy = "read-through"
+	// writes
+	WriteThrough Strategy = "write-through"
+	WriteAround  Strategy = "write-around"
+	WriteBehind  Strategy = "write-behind"
+	// either: go straight to the database
+	NoCache Strategy = "no-cache"
+)
+
+type Operation string
+
+const (
+	OpGet    Operation = "get"
+	OpList   Operation = "list"
+	OpCreate Operation = "create"
+	OpUpdate Operation = "update"
+)
+
+// IsRead reports whether op reads data, which decides the strategies it
+// accepts.
+func (op Operation) IsRead() bool {
+	return op == OpGet || op == OpList
+}
+
+var (
+	ErrUnknownEntity      = errors.New("unknown entity")
+	ErrUnknownOperation   = errors.New("unknown operation")
+	ErrInvalidStrategy    = errors.New("strategy not valid for this operation")
+	ErrStrategyNotEnabled = errors.New("strategy not available on this server")
+)
+
+// Valid reports whether s can be used for op.
+func Valid(op Operation, s Strategy) bool {
+	switch s {
+	case NoCache:
+		return true
+	case CacheAside, ReadThrough:
+		return op.IsRead()
+	case WriteThrough, WriteAround, WriteBehind:
+		return !op.IsRead()
+	}
+	return false
+}
+
+// Setting is the strategy of one entity operation.
+type Setting struct {
+	Entity    string    `json:"entity"`
+	Operation Operation `json:"operation"`
+	Strategy  Strategy  `json:"strategy"`
+	// Default is true while the setting hasn't been changed through Set.
+	Default bool `json:"default"`
+}
+
+type key struct {
+	entity string
+	op     Operation
+}
+
+// Registry maps entity operations to strategies.
+type Registry struct {
+	db *gorm.DB
+
+	mu          sync.RWMutex
+	defaults    map[key]Strategy
+	overrides   map[key]Strategy
+	unsupported map[Strategy]string
+}
+
+// Default is the registry used by the routers, set in main.
+var Default *Registry
+
+func NewRegistry(db *gorm.DB) *Registry {
+	return &Registry{
+		db:          db,
+		defaults:    make(map[key]Strategy),
+		overrides:   make(map[key]Strategy),
+		unsupported: make(map[Strategy]string),
+	}
+}
+
+// Register declares an entity with the strategy of each of its operations
+// until one is changed.
+func (r *Registry) Register(entity string, defaults map[Operation]Strategy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for op, s := range defaults {
+		r.defaults[key{entity, op}] = s
+	}
+}
+
+// Disable makes Set reject s, for strategies whose backing service isn't
+// running (write-behind needs Redis Streams).
+func (r *Registry) Disable(s Strategy, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unsupported[s] = reason
+}
+
+// Get returns the strategy for an entity operation, or "" if it was never
+// registered.
+func (r *Registry) Get(entity string, op Operation) Strategy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k := key{entity, op}
+	if s, ok := r.overrides[k]; ok {
+		return s
+	}
+	return r.defaults[k]
+}
+
+// Set switches the strategy of an entity operation and persists it.
+func (r *Registry) Set(ctx context.Context, entity string, op Operation, s Strategy) error {
+	k := key{entity, op}
+
+	r.mu.RLock()
+	_, known := r.defaults[k]
+	reason, disabled := r.unsupported[s]
+	r.mu.RUnlock()
+
+	switch {
+	case !known && !r.hasEntity(entity):
+		return fmt.Errorf("%w %q", ErrUnknownEntity, entity)
+	case !known:
+		return fmt.Errorf("%w %q for %s", ErrUnknownOperation, op, entity)
+	case !Valid(op, s):
+		return fmt.Errorf("%w: %q on %s", ErrInvalidStrategy, s, op)
+	case disabled:
+		return fmt.Errorf("%w: %s: %s", ErrStrategyNotEnabled, s, reason)
+	}
+
+	row := models.CacheStrategy{Entity: entity, Operation: string(op), Strategy: string(s)}
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&row).Error
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.overrides[k] = s
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Registry) hasEntity(entity string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for k := range r.defaults {
+		if k.entity == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// All lists every registered setting, sorted by entity and operation.
+func (r *Registry) All() []Setting {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	settings := make([]Setting, 0, len(r.defaults))
+	for k, s := range r.defaults {
+		setting := Setting{Entity: k.entity, Operation: k.op, Strategy: s, Default: true}
+		if o, ok := r.overrides[k]; ok {
+			setting.Strategy = o
+			setting.Default = false
+		}
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		if settings[i].Entity != settings[j].Entity {
+			return settings[i].Entity < settings[j].Entity
+		}
+		return settings[i].Operation < settings[j].Operation
+	})
+	return settings
+}
+
+// Load replaces the in-memory settings with the ones persisted in the
+// database. Rows that are no longer valid (an entity removed from the code,
+// a strategy disabled on this server) are skipped.
+func (r *Registry) Load(ctx context.Context) error {
+	var rows []models.CacheStrategy
+	if err := r.db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	overrides := make(map[key]Strategy, len(rows))
+	r.mu.RLock()
+	for _, row := range rows {
+		k := key{row.Entity, Operation(row.Operation)}
+		s := Strategy(row.Strategy)
+		if _, known := r.defaults[k]; !known || !Valid(k.op, s) {
+			continue
+		}
+		if _, disabled := r.unsupported[s]; disabled {
+			continue
+		}
+		overrides[k] = s
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	r.overrides = overrides
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the settings every interval until ctx is done, so a change
+// made through another instance is picked up here as well.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Load(ctx); err != nil {
+				log.Println("Reloading cache strategies:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,507 @@
+// Package writebehind implements the write-behind caching strategy: writes
+// are committed to Redis right away and appended to a Redis Stream, and a
+// background flusher replays them into MySQL in batches.
+//
+// The stream is consumed through a consumer group, so a mutation stays
+// pending until it has been written to MySQL. Mutations that keep failing
+// are moved to a dead-letter stream instead of blocking the queue.
+package writebehind
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wailman24/Caching.git/pkg/cache/keys"
+	"gorm.io/gorm"
+)
+
+// Mutation is one write waiting to be flushed to the database.
+type Mutation struct {
+	Entity  string          `json:"entity"`
+	Op      string          `json:"op"`
+	ID      uint            `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+)
+
+// Applier writes one mutation of an entity inside the flush transaction.
+type Applier func(tx *gorm.DB, m Mutation) error
+
+// ErrNotCreated is returned by appliers for an update of a row whose create
+// hasn't been flushed yet. Like any failed mutation, it stays pending and
+// is retried.
+var ErrNotCreated = errors.New("row not created yet")
+
+// Config tunes the flusher.
+type Config struct {
+	Stream     string
+	Group      string
+	Consumer   string
+	DeadLetter string
+	// BatchSize is the most mutations written per transaction.
+	BatchSize int64
+	// FlushInterval is how long the flusher waits for new mutations.
+	FlushInterval time.Duration
+	// RetryAfter is how long a failed mutation stays pending before it is
+	// retried.
+	RetryAfter time.Duration
+	// MaxRetries is how many deliveries a mutation gets before it is moved
+	// to the dead-letter stream.
+	MaxRetries int64
+}
+
+// DefaultConfig returns the settings used when none are configured. The
+// streams are in the keys.Default namespace, so it must be called once
+// that is set.
+func DefaultConfig() Config {
+	host, _ := os.Hostname()
+	return Config{
+		Stream:        keys.Default.Key("writebehind", "mutations"),
+		Group:         "writebehind-flushers",
+		Consumer:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+		DeadLetter:    keys.Default.Key("writebehind", "dead"),
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		RetryAfter:    10 * time.Second,
+		MaxRetries:    5,
+	}
+}
+
+// Stats is a snapshot of the queue counters.
+type Stats struct {
+	Enqueued     int64 `json:"enqueued"`
+	Flushed      int64 `json:"flushed"`
+	Batches      int64 `json:"batches"`
+	Failed       int64 `json:"failed"`
+	DeadLettered int64 `json:"dead_lettered"`
+	// Pending is the number of delivered but not yet flushed mutations.
+	Pending int64 `json:"pending"`
+	// Backlog is the number of mutations not yet flushed. Flushed entries
+	// are deleted from the stream.
+	Backlog int64 `json:"backlog"`
+}
+
+// Breaker guards the calls to Redis, see cache.Breaker.
+type Breaker interface {
+	Do(fn func() error) error
+	Record(err error)
+	Open() bool
+}
+
+// Queue appends mutations to the stream and flushes them to the database.
+type Queue struct {
+	rdb      *redis.Client
+	db       *gorm.DB
+	cfg      Config
+	appliers map[string]Applier
+	onDead   func(ctx context.Context, m Mutation)
+	breaker  Breaker
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	enqueued     atomic.Int64
+	flushed      atomic.Int64
+	batches      atomic.Int64
+	failed       atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// Default is the queue started by main when write-behind is enabled.
+var Default *Queue
+
+func New(rdb *redis.Client, db *gorm.DB, cfg Config) *Queue {
+	return &Queue{rdb: rdb, db: db, cfg: cfg, appliers: make(map[string]Applier)}
+}
+
+// Register sets the function that writes mutations of entity to the
+// database. It must be called before Start.
+func (q *Queue) Register(entity string, apply Applier) {
+	q.appliers[entity] = apply
+}
+
+// OnDeadLetter sets a function called for each mutation given up on, for
+// instance to evict the cached value that never made it to the database.
+func (q *Queue) OnDeadLetter(fn func(ctx context.Context, m Mutation)) {
+	q.onDead = fn
+}
+
+// Guard routes Enqueue, NextID, Stats and Queued through b: they fail with
+// its error while it is open, and their failures count towards opening it. The
+// flusher reports its errors to b and pauses while b is open.
+func (q *Queue) Guard(b Breaker) {
+	q.breaker = b
+}
+
+// Enqueue durably records a mutation. Once it returns, the mutation will
+// reach the database even if this process dies.
+func (q *Queue) Enqueue(ctx context.Context, m Mutation) error {
+	err := q.do(func() error {
+		return q.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: q.cfg.Stream,
+			Values: map[string]interface{}{
+				"entity":  m.Entity,
+				"op":      m.Op,
+				"id":      m.ID,
+				"payload": string(m.Payload),
+			},
+		}).Err()
+	})
+	if err != nil {
+		return err
+	}
+	q.enqueued.Add(1)
+	return nil
+}
+
+// Start creates the consumer group if needed and runs the flusher in the
+// background until Shutdown.
+func (q *Queue) Start(ctx context.Context) error {
+	err := q.do(func() error {
+		return q.rdb.XGroupCreateMkStream(ctx, q.cfg.Stream, q.cfg.Group, "0").Err()
+	})
+	if err != nil && !isBusyGroup(err) {
+		return err
+	}
+
+	// The flusher outlives the startup context and stops on Shutdown
+	runCtx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	go q.run(runCtx)
+	return nil
+}
+
+// Shutdown stops the flusher and writes everything still queued before
+// returning, or gives up when ctx expires.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+	<-q.done
+
+	for {
+		n, err := q.flushNew(ctx, -1)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	// Retry what this consumer failed to write earlier until it is written
+	// or has used up its retries and been dead-lettered. The mutations
+	// pending on other consumers may be being written right now: they are
+	// left to them, or to the next retry of another instance.
+	for i := int64(0); i <= q.cfg.MaxRetries; i++ {
+		n, err := q.retryPending(ctx, 0, q.cfg.Consumer)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func (q *Queue) run(ctx context.Context) {
+	defer close(q.done)
+
+	lastRetry := time.Now()
+	for ctx.Err() == nil {
+		if q.breaker != nil && q.breaker.Open() {
+			// Redis is down, the mutations wait in the stream
+			time.Sleep(q.cfg.FlushInterval)
+			continue
+		}
+		// The reads block for up to FlushInterval, so only their errors
+		// are reported to the breaker
+		if _, err := q.flushNew(ctx, q.cfg.FlushInterval); err != nil && ctx.Err() == nil {
+			log.Println("write-behind flush failed:", err)
+			q.record(err)
+			time.Sleep(q.cfg.FlushInterval)
+		}
+		if time.Since(lastRetry) >= q.cfg.RetryAfter {
+			lastRetry = time.Now()
+			if _, err := q.retryPending(ctx, q.cfg.RetryAfter, ""); err != nil && ctx.Err() == nil {
+				log.Println("write-behind retry failed:", err)
+				q.record(err)
+			}
+		}
+	}
+}
+
+// flushNew reads mutations not yet delivered to the group and writes them.
+// A negative block returns immediately when the stream is empty.
+func (q *Queue) flushNew(ctx context.Context, block time.Duration) (int, error) {
+	streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.cfg.Group,
+		Consumer: q.cfg.Consumer,
+		Streams:  []string{q.cfg.Stream, ">"},
+		Count:    q.cfg.BatchSize,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range streams {
+		n += len(s.Messages)
+		q.flushBatch(ctx, s.Messages)
+	}
+	return n, nil
+}
+
+// flushBatch writes messages in one transaction. If that fails, it falls
+// back to one transaction per message so a single bad mutation doesn't hold
+// back the rest; the ones that still fail stay pending for a retry.
+func (q *Queue) flushBatch(ctx context.Context, msgs []redis.XMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, msg := range msgs {
+			if err := q.apply(tx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		q.ack(ctx, msgs...)
+		q.batches.Add(1)
+		return
+	}
+
+	for _, msg := range msgs {
+		err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return q.apply(tx, msg)
+		})
+		if err != nil {
+			q.failed.Add(1)
+			log.Printf("write-behind mutation %s failed: %v", msg.ID, err)
+			continue
+		}
+		q.ack(ctx, msg)
+	}
+}
+
+// retryPending claims mutations idle for at least minIdle, from consumer
+// or from any consumer of the group if it is empty, and writes them again.
+// Those delivered more than MaxRetries times go to the dead-letter stream.
+func (q *Queue) retryPending(ctx context.Context, minIdle time.Duration, consumer string) (int, error) {
+	pending, err := q.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   q.cfg.Stream,
+		Group:    q.cfg.Group,
+		Idle:     minIdle,
+		Start:    "-",
+		End:      "+",
+		Count:    q.cfg.BatchSize,
+		Consumer: consumer,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	var retry, dead []string
+	for _, p := range pending {
+		if p.RetryCount > q.cfg.MaxRetries {
+			dead = append(dead, p.ID)
+		} else {
+			retry = append(retry, p.ID)
+		}
+	}
+
+	for _, id := range dead {
+		q.deadLetter(ctx, id)
+	}
+
+	if len(retry) == 0 {
+		return len(dead), nil
+	}
+	msgs, err := q.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   q.cfg.Stream,
+		Group:    q.cfg.Group,
+		Consumer: q.cfg.Consumer,
+		MinIdle:  minIdle,
+		Messages: retry,
+	}).Result()
+	if err != nil {
+		return len(dead), err
+	}
+	q.flushBatch(ctx, msgs)
+	return len(dead) + len(msgs), nil
+}
+
+// deadLetter copies a mutation to the dead-letter stream and acknowledges
+// it so it is no longer retried.
+func (q *Queue) deadLetter(ctx context.Context, id string) {
+	msgs, err := q.rdb.XRangeN(ctx, q.cfg.Stream, id, id, 1).Result()
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		values := msg.Values
+		values["source_id"] = msg.ID
+		if err := q.rdb.XAdd(ctx, &redis.XAddArgs{Stream: q.cfg.DeadLetter, Values: values}).Err(); err != nil {
+			return
+		}
+		log.Printf("write-behind mutation %s moved to %s", msg.ID, q.cfg.DeadLetter)
+		if m, err := decode(msg); err == nil && q.onDead != nil {
+			q.onDead(ctx, m)
+		}
+	}
+	q.rdb.XAck(ctx, q.cfg.Stream, q.cfg.Group, id)
+	q.rdb.XDel(ctx, q.cfg.Stream, id)
+	q.deadLettered.Add(1)
+}
+
+// ack marks messages as flushed and drops them from the stream.
+func (q *Queue) ack(ctx context.Context, msgs ...redis.XMessage) {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	pipe := q.rdb.Pipeline()
+	pipe.XAck(ctx, q.cfg.Stream, q.cfg.Group, ids...)
+	pipe.XDel(ctx, q.cfg.Stream, ids...)
+	if _, err := pipe.Exec(ctx); err == nil {
+		q.flushed.Add(int64(len(ids)))
+	}
+}
+
+func (q *Queue) apply(tx *gorm.DB, msg redis.XMessage) error {
+	m, err := decode(msg)
+	if err != nil {
+		return err
+	}
+	apply, ok := q.appliers[m.Entity]
+	if !ok {
+		return fmt.Errorf("no write-behind applier for entity %q", m.Entity)
+	}
+	return apply(tx, m)
+}
+
+func decode(msg redis.XMessage) (Mutation, error) {
+	str := func(k string) string {
+		s, _ := msg.Values[k].(string)
+		return s
+	}
+	id, err := strconv.ParseUint(str("id"), 10, 64)
+	if err != nil {
+		return Mutation{}, fmt.Errorf("mutation %s: invalid id: %w", msg.ID, err)
+	}
+	return Mutation{
+		Entity:  str("entity"),
+		Op:      str("op"),
+		ID:      uint(id),
+		Payload: json.RawMessage(str("payload")),
+	}, nil
+}
+
+// Stats returns the counters of this instance, and the pending and backlog
+// counts of the stream, left at 0 when Redis can't be asked.
+func (q *Queue) Stats(ctx context.Context) Stats {
+	stats := Stats{
+		Enqueued:     q.enqueued.Load(),
+		Flushed:      q.flushed.Load(),
+		Batches:      q.batches.Load(),
+		Failed:       q.failed.Load(),
+		DeadLettered: q.deadLettered.Load(),
+	}
+	_ = q.do(func() error {
+		p, err := q.rdb.XPending(ctx, q.cfg.Stream, q.cfg.Group).Result()
+		if err == nil {
+			stats.Pending = p.Count
+		}
+		return err
+	})
+	_ = q.do(func() (err error) {
+		stats.Backlog, err = q.rdb.XLen(ctx, q.cfg.Stream).Result()
+		return err
+	})
+	return stats
+}
+
+// Queued reports whether mutations are waiting to be written, delivered or
+// not: flushed ones are deleted from the stream. It fails when Redis can't
+// be asked.
+func (q *Queue) Queued(ctx context.Context) (bool, error) {
+	var n int64
+	err := q.do(func() (err error) {
+		n, err = q.rdb.XLen(ctx, q.cfg.Stream).Result()
+		return err
+	})
+	return n > 0, err
+}
+
+func isBusyGroup(err error) bool {
+	return strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
+
+// nextIDScript raises a sequence to at least ARGV[1], then increments it.
+var nextIDScript = redis.NewScript(`
+local cur = tonumber(redis.call("GET", KEYS[1]) or "0")
+local floor = tonumber(ARGV[1])
+if cur < floor then
+	cur = floor
+end
+cur = cur + 1
+redis.call("SET", KEYS[1], cur)
+return cur`)
+
+// NextID allocates an ID from the Redis sequence at key. Write-behind
+// creates need their ID before the row exists in MySQL, so they take it
+// from here instead of AUTO_INCREMENT. Before each allocation the sequence
+// is raised to the value returned by seed (typically MAX(id)), in the same
+// script as the increment, so it never hands out the ID of a row inserted
+// with AUTO_INCREMENT by the other strategies.
+func (q *Queue) NextID(ctx context.Context, key string, seed func(ctx context.Context) (uint, error)) (uint, error) {
+	max, err := seed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = q.do(func() (err error) {
+		id, err = nextIDScript.Run(ctx, q.rdb, []string{key}, max).Int64()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// do runs a call to Redis through the breaker, if any.
+func (q *Queue) do(fn func() error) error {
+	if q.breaker == nil {
+		return fn()
+	}
+	return q.breaker.Do(fn)
+}
+
+func (q *Queue) record(err error) {
+	if q.breaker != nil {
+		q.breaker.Record(err)
+	}
+}
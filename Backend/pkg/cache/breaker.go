@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCircuitOpen is returned instead of calling the cache while the
+// breaker is open.
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// BreakerState is the state of a Breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects every call until OpenFor has passed.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets Probes calls through: the breaker closes if
+	// they all succeed and opens again on the first failure.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig sets when a Breaker opens and how it recovers.
+type BreakerConfig struct {
+	// Window is how long calls are counted for before the counts restart.
+	Window time.Duration
+	// MinCalls is how many calls a window needs before the rates below
+	// are checked, so a single failure on an idle instance doesn't open.
+	MinCalls int
+	// ErrorRate opens the breaker when this share of the calls of a
+	// window failed (0.5 = half of them).
+	ErrorRate float64
+	// SlowCall is how long a call may take before it counts as slow, and
+	// SlowRate the share of slow calls that opens the breaker. 0 disables
+	// the latency threshold.
+	SlowCall time.Duration
+	SlowRate float64
+	// OpenFor is how long the breaker stays open before probing.
+	OpenFor time.Duration
+	// Probes is how many calls are let through while half-open.
+	Probes int
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:    10 * time.Second,
+		MinCalls:  20,
+		ErrorRate: 0.5,
+		SlowCall:  250 * time.Millisecond,
+		SlowRate:  0.8,
+		OpenFor:   5 * time.Second,
+		Probes:    3,
+	}
+}
+
+// BreakerStats is a snapshot of a breaker.
+type BreakerStats struct {
+	State BreakerState `json:"state"`
+	// Since is when the breaker entered State.
+	Since time.Time `json:"since"`
+	// LastError is the failure that last opened the breaker.
+	LastError string `json:"last_error,omitempty"`
+	// Calls, Failures and Slow are counted over the current window.
+	Calls    int64 `json:"calls"`
+	Failures int64 `json:"failures"`
+	Slow     int64 `json:"slow"`
+	// Opened and Rejected are totals since startup.
+	Opened   int64 `json:"opened"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Breaker is a circuit breaker around calls to a failing dependency. While
+// closed it counts the failed and slow calls; past the thresholds it
+// opens, and calls fail right away with ErrCircuitOpen instead of each
+// waiting for a timeout. After OpenFor it lets a few probes through
+// (half-open) to decide whether to close again.
+type Breaker struct {
+	cfg BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	since       time.Time
+	lastError   string
+	windowStart time.Time
+	calls       int64
+	failures    int64
+	slow        int64
+	probes      int // let through while half-open
+	succeeded   int // probes that succeeded
+	listeners   []func(from, to BreakerState)
+
+	opened   atomic.Int64
+	rejected atomic.Int64
+}
+
+// StoreBreaker guards Store when it is Redis, set by GuardStore. It is nil
+// with the in-memory backend, which can't fail.
+var StoreBreaker *Breaker
+
+// GuardStore puts a Breaker with cfg in front of Store. It starts open if
+// Redis didn't answer in ConnectRedis.
+func GuardStore(cfg BreakerConfig) *Breaker {
+	b := NewBreaker(cfg)
+	if connectErr != nil {
+		b.Trip(connectErr)
+	}
+	StoreBreaker = b
+	Store = NewBreakerCache(Store, b)
+	return b
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	now := time.Now()
+	return &Breaker{cfg: cfg, state: BreakerClosed, since: now, windowStart: now}
+}
+
+// OnStateChange registers fn to be called, outside the breaker lock, on
+// every transition.
+func (b *Breaker) OnStateChange(fn func(from, to BreakerState)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Allow returns ErrCircuitOpen if the call must not be made. Every allowed
+// call must be followed by Done.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+	if b.state == BreakerOpen && time.Since(b.since) >= b.cfg.OpenFor {
+		b.setState(BreakerHalfOpen)
+	}
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.Probes {
+			allowed = false
+		} else {
+			b.probes++
+		}
+	}
+	notify := b.transition(from)
+	b.mu.Unlock()
+	notify()
+
+	if !allowed {
+		b.rejected.Add(1)
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Done records the outcome of a call Allow let through, started at start.
+func (b *Breaker) Done(start time.Time, err error) {
+	failed := isOutage(err)
+	slow := b.cfg.SlowCall > 0 && time.Since(start) >= b.cfg.SlowCall
+	if !failed {
+		// Not the reason the breaker may open
+		err = nil
+	}
+
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerHalfOpen:
+		switch {
+		case failed || slow:
+			b.open(err)
+		default:
+			b.succeeded++
+			if b.succeeded >= b.cfg.Probes {
+				b.setState(BreakerClosed)
+			}
+		}
+	case BreakerClosed:
+		if time.Since(b.windowStart) >= b.cfg.Window {
+			b.resetWindow()
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slow++
+		}
+		if b.calls >= int64(b.cfg.MinCalls) && b.tripped() {
+			b.open(err)
+		}
+	}
+	notify := b.transition(from)
+	b.mu.Unlock()
+	notify()
+}
+
+// Trip opens the breaker, e.g. when Redis didn't answer at startup.
+func (b *Breaker) Trip(err error) {
+	b.mu.Lock()
+	from := b.state
+	b.open(err)
+	notify := b.transition(from)
+	b.mu.Unlock()
+	notify()
+}
+
+// Do runs fn if the breaker allows it and records its outcome, for the
+// Redis clients used outside Store: the invalidation bus, the write-behind
+// queue and the listeners. A nil breaker just runs fn.
+func (b *Breaker) Do(fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	_, err := guard(b, func() (struct{}, error) { return struct{}{}, fn() })
+	return err
+}
+
+// Record counts err as the outcome of a call made without Allow, e.g. a
+// subscription that broke while blocked on a read, which can't be timed.
+// It is dropped while the breaker is open.
+func (b *Breaker) Record(err error) {
+	_ = b.Do(func() error { return err })
+}
+
+// Open reports whether calls are currently rejected, so a background loop
+// can wait instead of retrying. It is false for a nil breaker.
+func (b *Breaker) Open() bool {
+	return b != nil && b.State() == BreakerOpen
+}
+
+// State returns the current state. An open breaker whose OpenFor has
+// passed reads as half-open: the next call probes.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.since) >= b.cfg.OpenFor {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) Stats() BreakerStats {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		State:     state,
+		Since:     b.since,
+		LastError: b.lastError,
+		Calls:     b.calls,
+		Failures:  b.failures,
+		Slow:      b.slow,
+		Opened:    b.opened.Load(),
+		Rejected:  b.rejected.Load(),
+	}
+}
+
+func (b *Breaker) tripped() bool {
+	calls := float64(b.calls)
+	if b.cfg.ErrorRate > 0 && float64(b.failures)/calls >= b.cfg.ErrorRate {
+		return true
+	}
+	return b.cfg.SlowCall > 0 && b.cfg.SlowRate > 0 && float64(b.slow)/calls >= b.cfg.SlowRate
+}
+
+// open is called with b.mu held.
+func (b *Breaker) open(err error) {
+	if err != nil {
+		b.lastError = err.Error()
+	} else {
+		b.lastError = "calls too slow"
+	}
+	b.setState(BreakerOpen)
+	b.opened.Add(1)
+}
+
+// setState is called with b.mu held.
+func (b *Breaker) setState(s BreakerState) {
+	b.state = s
+	b.since = time.Now()
+	b.probes = 0
+	b.succeeded = 0
+	b.resetWindow()
+}
+
+func (b *Breaker) resetWindow() {
+	b.windowStart = time.Now()
+	b.calls, b.failures, b.slow = 0, 0, 0
+}
+
+// transition is called with b.mu held, and returns what notifies the
+// listeners if the state changed since from.
+func (b *Breaker) transition(from BreakerState) func() {
+	to := b.state
+	if to == from || len(b.listeners) == 0 {
+		return func() {}
+	}
+	listeners := b.listeners
+	return func() {
+		for _, fn := range listeners {
+			fn(from, to)
+		}
+	}
+}
+
+// isOutage tells whether err means the cache is unavailable. An error
+// reply such as WRONGTYPE comes from a working server, and a request the
+// client cancelled says nothing about Redis.
+func isOutage(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
+
+// guard runs fn if b allows it, and records its outcome.
+func guard[R any](b *Breaker, fn func() (R, error)) (R, error) {
+	if err := b.Allow(); err != nil {
+		var zero R
+		return zero, err
+	}
+	start := time.Now()
+	res, err := fn()
+	b.Done(start, err)
+	return res, err
+}
+
+// BreakerCache guards a Cache with a Breaker: while it is open every call
+// fails with ErrCircuitOpen, which the repositories handle like any cache
+// error, by going to the database.
+type BreakerCache struct {
+	c Cache
+	b *Breaker
+}
+
+func NewBreakerCache(c Cache, b *Breaker) *BreakerCache {
+	return &BreakerCache{c: c, b: b}
+}
+
+// Unwrap returns the guarded cache.
+func (bc *BreakerCache) Unwrap() Cache {
+	return bc.c
+}
+
+func (bc *BreakerCache) Breaker() *Breaker {
+	return bc.b
+}
+
+func (bc *BreakerCache) HSet(ctx context.Context, key string, values map[string]string) error {
+	_, err := guard(bc.b, func() (struct{}, error) { return struct{}{}, bc.c.HSet(ctx, key, values) })
+	return err
+}
+
+func (bc *BreakerCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return guard(bc.b, func() (map[string]string, error) { return bc.c.HGetAll(ctx, key) })
+}
+
+func (bc *BreakerCache) HGetAllMany(ctx context.Context, keys []string) ([]map[string]string, error) {
+	return guard(bc.b, func() ([]map[string]string, error) { return bc.c.HGetAllMany(ctx, keys) })
+}
+
+func (bc *BreakerCache) SAdd(ctx context.Context, key string, members ...string) error {
+	_, err := guard(bc.b, func() (struct{}, error) { return struct{}{}, bc.c.SAdd(ctx, key, members...) })
+	return err
+}
+
+func (bc *BreakerCache) SRem(ctx context.Context, key string, members ...string) error {
+	_, err := guard(bc.b, func() (struct{}, error) { return struct{}{}, bc.c.SRem(ctx, key, members...) })
+	return err
+}
+
+func (bc *BreakerCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return guard(bc.b, func() ([]string, error) { return bc.c.SMembers(ctx, key) })
+}
+
+func (bc *BreakerCache) Del(ctx context.Context, keys ...string) error {
+	_, err := guard(bc.b, func() (struct{}, error) { return struct{}{}, bc.c.Del(ctx, keys...) })
+	return err
+}
+
+func (bc *BreakerCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := guard(bc.b, func() (struct{}, error) { return struct{}{}, bc.c.Expire(ctx, key, ttl) })
+	return err
+}
+
+func (bc *BreakerCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return guard(bc.b, func() (time.Duration, error) { return bc.c.TTL(ctx, key) })
+}
+
+func (bc *BreakerCache) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return guard(bc.b, func() (bool, error) { return bc.c.SetNX(ctx, key, value, ttl) })
+}
+
+func (bc *BreakerCache) DelIfEqual(ctx context.Context, key, value string) (bool, error) {
+	return guard(bc.b, func() (bool, error) { return bc.c.DelIfEqual(ctx, key, value) })
+}
+
+func (bc *BreakerCache) ExpireIfEqual(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return guard(bc.b, func() (bool, error) { return bc.c.ExpireIfEqual(ctx, key, value, ttl) })
+}
+
+// Ping bypasses the breaker, so health checks see the cache itself.
+func (bc *BreakerCache) Ping(ctx context.Context) error {
+	return bc.c.Ping(ctx)
+}
+
+func (bc *BreakerCache) Pipeline() Pipeline {
+	return breakerPipeline{Pipeline: bc.c.Pipeline(), b: bc.b}
+}
+
+// ServerStats and Keys forward to the guarded cache, for the dashboard.
+func (bc *BreakerCache) ServerStats(ctx context.Context) (ServerStats, error) {
+	in, ok := bc.c.(Inspector)
+	if !ok {
+		return ServerStats{}, errors.ErrUnsupported
+	}
+	return guard(bc.b, func() (ServerStats, error) { return in.ServerStats(ctx) })
+}
+
+func (bc *BreakerCache) Keys(ctx context.Context, pattern string, limit int) ([]KeyInfo, error) {
+	in, ok := bc.c.(Inspector)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return guard(bc.b, func() ([]KeyInfo, error) { return in.Keys(ctx, pattern, limit) })
+}
+
+// breakerPipeline only guards Exec, queueing commands sends nothing.
+type breakerPipeline struct {
+	Pipeline
+	b *Breaker
+}
+
+func (p breakerPipeline) Exec(ctx context.Context) error {
+	_, err := guard(p.b, func() (struct{}, error) { return struct{}{}, p.Pipeline.Exec(ctx) })
+	return err
+}
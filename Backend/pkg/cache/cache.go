@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Cache is the set of operations the repositories need from a cache backend.
+// It mirrors the Redis commands we use so the Redis implementation stays a
+// thin wrapper, while MemoryCache lets the app run without a Redis server.
+type Cache interface {
+	// Hashes
+	HSet(ctx context.Context, key string, values map[string]string) error
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HGetAllMany reads several hashes in one round-trip, returning one
+	// map per key in the same order. Missing keys, and keys that don't
+	// hold a hash, come back as empty maps.
+	HGetAllMany(ctx context.Context, keys []string) ([]map[string]string, error)
+
+	// Sets
+	SAdd(ctx context.Context, key string, members ...string) error
+	SRem(ctx context.Context, key string, members ...string) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+
+	// Keys and TTL
+	Del(ctx context.Context, keys ...string) error
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// Locks
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// DelIfEqual deletes key only if it holds value, so a lock is only
+	// released by its owner.
+	DelIfEqual(ctx context.Context, key, value string) (bool, error)
+	// ExpireIfEqual resets the TTL of key only if it holds value.
+	ExpireIfEqual(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+
+	Pipeline() Pipeline
+	Ping(ctx context.Context) error
+}
+
+// Pipeline queues write commands and sends them in a single round-trip.
+// Nothing is applied until Exec is called.
+type Pipeline interface {
+	HSet(key string, values map[string]string)
+	SAdd(key string, members ...string)
+	SRem(key string, members ...string)
+	Del(keys ...string)
+	Expire(key string, ttl time.Duration)
+	// SAddExisting adds members only if the set already exists, so that a
+	// single write can't create an index holding just one member.
+	SAddExisting(key string, members ...string)
+	// HReplaceIfNewer replaces the hash at key with values and sets its TTL
+	// (0 = no expiry), unless the hash holds a greater number in
+	// versionField than values does. An older refill then can't overwrite
+	// a newer write.
+	HReplaceIfNewer(key string, values map[string]string, versionField string, ttl time.Duration)
+	Exec(ctx context.Context) error
+}
+
+// TTL sentinels, same values go-redis returns for TTL.
+const (
+	NoExpiry   time.Duration = -1
+	KeyMissing time.Duration = -2
+)
+
+// Store is the cache backend selected by Connect.
+var Store Cache
+
+// Connect picks the backend from CACHE_BACKEND ("redis" by default, or
+// "memory" for local dev and tests without a Redis container).
+func Connect() {
+	backend := os.Getenv("CACHE_BACKEND")
+	switch backend {
+	case "", "redis":
+		ConnectRedis()
+		Store = NewRedisCache(Rdb)
+	case "memory":
+		Store = NewMemoryCache()
+		fmt.Println("Using in-memory cache backend")
+	default:
+		log.Fatalf("Unknown CACHE_BACKEND %q (expected redis or memory)", backend)
+	}
+}
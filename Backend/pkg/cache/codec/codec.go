@@ -0,0 +1,236 @@
+// Package codec serializes cached values. A Codec encodes with one Format
+// (JSON, MessagePack or Protobuf) and compresses values above a size
+// threshold (zstd or snappy). Every encoded value starts with a two byte
+// marker naming its format and compression:
+//
+//	<format><compression><payload>   e.g. "j-{...}" or "mz\x28\xb5..."
+//
+// Decode reads the marker rather than the configuration, so the format can
+// be switched without flushing the cache: values written before the switch
+// stay readable until they expire or are rewritten.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Format marshals values to bytes.
+type Format interface {
+	Name() string
+	// Marker is the first byte of the values it encodes.
+	Marker() byte
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Format names accepted by Parse.
+const (
+	FormatJSON     = "json"
+	FormatMsgpack  = "msgpack"
+	FormatProtobuf = "protobuf"
+)
+
+// Formats lists the available format names.
+var Formats = []string{FormatJSON, FormatMsgpack, FormatProtobuf}
+
+var (
+	JSON        Format = jsonFormat{}
+	MessagePack Format = msgpackFormat{}
+	// Protobuf encodes a proto.Message, or a value implementing
+	// ProtoMarshaler and ProtoUnmarshaler.
+	Protobuf Format = protobufFormat{}
+)
+
+var formats = map[byte]Format{
+	JSON.Marker():        JSON,
+	MessagePack.Marker(): MessagePack,
+	Protobuf.Marker():    Protobuf,
+}
+
+// ErrNotProto is returned by Protobuf for a value it can't encode.
+var ErrNotProto = errors.New("value is not a protobuf message")
+
+// ProtoMarshaler is implemented by values encoded to protobuf by hand (with
+// protowire) rather than generated from a .proto file.
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string                       { return FormatJSON }
+func (jsonFormat) Marker() byte                       { return 'j' }
+func (jsonFormat) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonFormat) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackFormat struct{}
+
+func (msgpackFormat) Name() string                       { return FormatMsgpack }
+func (msgpackFormat) Marker() byte                       { return 'm' }
+func (msgpackFormat) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackFormat) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type protobufFormat struct{}
+
+func (protobufFormat) Name() string { return FormatProtobuf }
+func (protobufFormat) Marker() byte { return 'p' }
+
+func (protobufFormat) Marshal(v any) ([]byte, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case ProtoMarshaler:
+		return m.MarshalProto()
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNotProto, v)
+	}
+}
+
+func (protobufFormat) Unmarshal(data []byte, v any) error {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	case ProtoUnmarshaler:
+		return m.UnmarshalProto(data)
+	default:
+		return fmt.Errorf("%w: %T", ErrNotProto, v)
+	}
+}
+
+// ErrCorrupt is returned by Decode for a value without a known marker.
+var ErrCorrupt = errors.New("cached value has no known codec marker")
+
+// Codec encodes values with a format, compressing those whose encoding is
+// at least threshold bytes. It is safe for concurrent use.
+type Codec struct {
+	format      Format
+	compression Compression
+	threshold   int
+
+	encoded    atomic.Int64
+	compressed atomic.Int64
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+}
+
+// Stats counts the values encoded by a Codec and what compression saved.
+type Stats struct {
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	Threshold   int    `json:"threshold"`
+	Encoded     int64  `json:"encoded"`
+	Compressed  int64  `json:"compressed"`
+	// BytesIn is the size of the encoded values before compression, and
+	// BytesOut after it, markers included.
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Default is the codec of this deployment, set in main.
+var Default = New(JSON, None, 0)
+
+// New returns a codec. A zero threshold with a compression compresses
+// every value.
+func New(format Format, compression Compression, threshold int) *Codec {
+	return &Codec{format: format, compression: compression, threshold: threshold}
+}
+
+// Parse builds a codec from a format and a compression name (case
+// insensitive), e.g. from the environment.
+func Parse(format, compression string, threshold int) (*Codec, error) {
+	var f Format
+	for _, candidate := range formats {
+		if strings.EqualFold(candidate.Name(), format) {
+			f = candidate
+		}
+	}
+	if f == nil {
+		return nil, fmt.Errorf("unknown codec %q (expected one of %s)", format, strings.Join(Formats, ", "))
+	}
+	c, err := ParseCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+	return New(f, c, threshold), nil
+}
+
+func (c *Codec) Format() Format {
+	return c.format
+}
+
+// Encode marshals v, compresses it if it is large enough, and prefixes the
+// marker.
+func (c *Codec) Encode(v any) ([]byte, error) {
+	payload, err := c.format.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	c.bytesIn.Add(int64(len(payload) + 2))
+	compression := None
+	if c.compression != None && len(payload) >= c.threshold {
+		compressed := c.compression.Compress(payload)
+		// Not worth it for values that don't shrink, e.g. already compact
+		// protobuf
+		if len(compressed) < len(payload) {
+			payload = compressed
+			compression = c.compression
+			c.compressed.Add(1)
+		}
+	}
+
+	out := make([]byte, 0, len(payload)+2)
+	out = append(out, c.format.Marker(), compression.Marker())
+	out = append(out, payload...)
+	c.encoded.Add(1)
+	c.bytesOut.Add(int64(len(out)))
+	return out, nil
+}
+
+// Decode unmarshals a value written by any codec into v.
+func (c *Codec) Decode(data []byte, v any) error {
+	return Decode(data, v)
+}
+
+// Decode unmarshals a value written by any codec into v, whatever the
+// format and compression it names.
+func Decode(data []byte, v any) error {
+	if len(data) < 2 {
+		return ErrCorrupt
+	}
+	format, ok := formats[data[0]]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrCorrupt, data[0])
+	}
+	compression, ok := compressions[data[1]]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrCorrupt, data[1])
+	}
+	payload, err := compression.Decompress(data[2:])
+	if err != nil {
+		return err
+	}
+	return format.Unmarshal(payload, v)
+}
+
+func (c *Codec) Stats() Stats {
+	return Stats{
+		Format:      c.format.Name(),
+		Compression: c.compression.Name(),
+		Threshold:   c.threshold,
+		Encoded:     c.encoded.Load(),
+		Compressed:  c.compressed.Load(),
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+	}
+}
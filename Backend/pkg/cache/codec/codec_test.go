@@ -0,0 +1,178 @@
+package codec
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/wailman24/Caching.git/internal/models"
+)
+
+// threshold is the size from which the test codecs compress.
+const threshold = 128
+
+var formatList = []Format{JSON, MessagePack, Protobuf}
+
+var compressionList = []Compression{None, Zstd, Snappy}
+
+func small() *models.Product {
+	return &models.Product{ID: 7, Name: "keyboard", Price: "40", Version: 3}
+}
+
+// large encodes to well above threshold, and compresses well.
+func large() *models.Product {
+	return &models.Product{ID: 8, Name: strings.Repeat("mechanical keyboard ", 40), Price: "120", Version: 1}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range formatList {
+		for _, comp := range compressionList {
+			for _, tc := range []struct {
+				name       string
+				value      *models.Product
+				compressed bool
+			}{
+				{name: "below threshold", value: small()},
+				{name: "above threshold", value: large(), compressed: comp != None},
+			} {
+				t.Run(f.Name()+"/"+comp.Name()+"/"+tc.name, func(t *testing.T) {
+					c := New(f, comp, threshold)
+					data, err := c.Encode(tc.value)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					wantComp := None.Marker()
+					if tc.compressed {
+						wantComp = comp.Marker()
+					}
+					if data[0] != f.Marker() || data[1] != wantComp {
+						t.Errorf("marker = %q, want %q", data[:2], []byte{f.Marker(), wantComp})
+					}
+
+					var got models.Product
+					if err := c.Decode(data, &got); err != nil {
+						t.Fatal(err)
+					}
+					if got != *tc.value {
+						t.Errorf("decoded %+v, want %+v", got, *tc.value)
+					}
+
+					st := c.Stats()
+					if st.Encoded != 1 || (st.Compressed == 1) != tc.compressed {
+						t.Errorf("stats = %+v", st)
+					}
+					if tc.compressed && st.BytesOut >= st.BytesIn {
+						t.Errorf("compressed %d bytes to %d", st.BytesIn, st.BytesOut)
+					}
+				})
+			}
+		}
+	}
+}
+
+// Values written before the codec was reconfigured stay readable by the
+// new one, in any direction.
+func TestDecodeAfterCodecChange(t *testing.T) {
+	var codecs []*Codec
+	for _, f := range formatList {
+		for _, comp := range compressionList {
+			codecs = append(codecs, New(f, comp, threshold))
+		}
+	}
+	for _, before := range codecs {
+		for _, after := range codecs {
+			for _, v := range []*models.Product{small(), large()} {
+				data, err := before.Encode(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got models.Product
+				if err := after.Decode(data, &got); err != nil {
+					t.Errorf("%s read by %s: %v", name(before), name(after), err)
+					continue
+				}
+				if got != *v {
+					t.Errorf("%s read by %s = %+v, want %+v", name(before), name(after), got, *v)
+				}
+			}
+		}
+	}
+}
+
+func name(c *Codec) string {
+	return c.Format().Name() + "+" + c.compression.Name()
+}
+
+func TestIncompressibleStoredAsIs(t *testing.T) {
+	b := make([]byte, 512)
+	rand.Read(b)
+	v := &models.Product{ID: 1, Name: hex.EncodeToString(b), Price: "1"}
+
+	for _, comp := range []Compression{Zstd, Snappy} {
+		c := New(Protobuf, comp, 0)
+		data, err := c.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Hex only shrinks so much: whichever way, the value decodes
+		var got models.Product
+		if err := Decode(data, &got); err != nil || got != *v {
+			t.Errorf("%s: decoded %+v, %v", comp.Name(), got, err)
+		}
+	}
+
+	// Random bytes don't shrink at all
+	c := New(JSON, Zstd, 0)
+	data, _ := c.Encode(b)
+	if data[1] != None.Marker() {
+		t.Errorf("marker = %q, want an uncompressed value", data[:2])
+	}
+	if st := c.Stats(); st.Compressed != 0 {
+		t.Errorf("compressed = %d, want 0", st.Compressed)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, data := range []string{"", "j", "x-{}", "jx{}"} {
+		var got models.Product
+		if err := Decode([]byte(data), &got); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decode(%q) = %v, want ErrCorrupt", data, err)
+		}
+	}
+	// A known marker on a broken payload
+	if err := Decode([]byte("jz{}"), new(models.Product)); err == nil {
+		t.Error("Decode of a bad zstd payload succeeded")
+	}
+}
+
+func TestProtobufNeedsAMessage(t *testing.T) {
+	if _, err := New(Protobuf, None, 0).Encode(map[string]string{"a": "b"}); !errors.Is(err, ErrNotProto) {
+		t.Errorf("Encode = %v, want ErrNotProto", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		format, compression string
+		ok                  bool
+	}{
+		{"json", "", true},
+		{"MsgPack", "zstd", true},
+		{"protobuf", "Snappy", true},
+		{"xml", "none", false},
+		{"json", "gzip", false},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.format, tt.compression, 0)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q, %q) = %v", tt.format, tt.compression, err)
+			continue
+		}
+		if err == nil && !strings.EqualFold(c.Format().Name(), tt.format) {
+			t.Errorf("Parse(%q) format = %s", tt.format, c.Format().Name())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression shrinks encoded values.
+type Compression interface {
+	Name() string
+	// Marker is the second byte of the values it compresses.
+	Marker() byte
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// Compression names accepted by ParseCompression.
+const (
+	CompressionNone   = "none"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// Compressions lists the available compression names.
+var Compressions = []string{CompressionNone, CompressionZstd, CompressionSnappy}
+
+var (
+	None Compression = noCompression{}
+	// Zstd compresses best, for large values.
+	Zstd Compression = newZstd()
+	// Snappy is faster but compresses less.
+	Snappy Compression = snappyCompression{}
+)
+
+var compressions = map[byte]Compression{
+	None.Marker():   None,
+	Zstd.Marker():   Zstd,
+	Snappy.Marker(): Snappy,
+}
+
+// ParseCompression returns a compression from its name (case insensitive),
+// "" being none.
+func ParseCompression(name string) (Compression, error) {
+	if name == "" {
+		return None, nil
+	}
+	for _, c := range compressions {
+		if strings.EqualFold(c.Name(), name) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression %q (expected one of %s)", name, strings.Join(Compressions, ", "))
+}
+
+type noCompression struct{}
+
+func (noCompression) Name() string                          { return CompressionNone }
+func (noCompression) Marker() byte                          { return '-' }
+func (noCompression) Compress(src []byte) []byte            { return src }
+func (noCompression) Decompress(src []byte) ([]byte, error) { return src, nil }
+
+// zstdCompression shares one encoder and one decoder: EncodeAll and
+// DecodeAll are safe for concurrent use.
+type zstdCompression struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstd() zstdCompression {
+	// Only fail on invalid options
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	return zstdCompression{enc: enc, dec: dec}
+}
+
+func (zstdCompression) Name() string { return CompressionZstd }
+func (zstdCompression) Marker() byte { return 'z' }
+
+func (z zstdCompression) Compress(src []byte) []byte {
+	return z.enc.EncodeAll(src, nil)
+}
+
+func (z zstdCompression) Decompress(src []byte) ([]byte, error) {
+	return z.dec.DecodeAll(src, nil)
+}
+
+type snappyCompression struct{}
+
+func (snappyCompression) Name() string { return CompressionSnappy }
+func (snappyCompression) Marker() byte { return 's' }
+
+func (snappyCompression) Compress(src []byte) []byte {
+	return s2.EncodeSnappy(nil, src)
+}
+
+func (snappyCompression) Decompress(src []byte) ([]byte, error) {
+	return s2.Decode(nil, src)
+}
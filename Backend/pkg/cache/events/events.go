@@ -0,0 +1,220 @@
+// Package events streams what the cache does (hits, misses, writes,
+// invalidations, locks, evictions and expiries) to live subscribers, such as the
+// dashboards watching /api/cache/events.
+//
+// Every subscriber sees the same events in the same order. Publishing
+// never blocks the request that caused the event: each subscriber has a
+// bounded buffer, and one that can't keep up loses events instead of
+// slowing everyone down. It is told how many with Dropped.
+package events
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is what happened.
+type Type string
+
+const (
+	Hit        Type = "hit"
+	Miss       Type = "miss"
+	Write      Type = "write"
+	Invalidate Type = "invalidate"
+	Lock       Type = "lock"
+	Eviction   Type = "eviction"
+	// Expiry and Delete are keys Redis reported as expired or deleted
+	// through keyspace notifications.
+	Expiry Type = "expiry"
+	Delete Type = "delete"
+)
+
+// Types lists every event type.
+var Types = []Type{Hit, Miss, Write, Invalidate, Lock, Eviction, Expiry, Delete}
+
+// Event is one thing the cache did.
+type Event struct {
+	// ID increases by one per event published on the bus.
+	ID     uint64 `json:"id"`
+	Type   Type   `json:"type"`
+	Entity string `json:"entity,omitempty"`
+	Key    string `json:"key,omitempty"`
+	// Detail qualifies the event, e.g. the tier of a hit ("l1", "l2"),
+	// what a write was ("create", "fill") or what happened to a lock.
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Filter selects the events a subscriber receives. The zero Filter
+// matches everything.
+type Filter struct {
+	// Types is empty for every type.
+	Types     []Type
+	Entity    string
+	KeyPrefix string
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	if f.Entity != "" && e.Entity != f.Entity {
+		return false
+	}
+	return strings.HasPrefix(e.Key, f.KeyPrefix)
+}
+
+// DefaultBuffer is the number of events a subscriber can fall behind by
+// before it loses some.
+const DefaultBuffer = 256
+
+// Stats is a snapshot of the bus counters.
+type Stats struct {
+	Subscribers int   `json:"subscribers"`
+	Published   int64 `json:"published"`
+	// Dropped counts events lost by subscribers whose buffer was full.
+	Dropped int64 `json:"dropped"`
+}
+
+// Bus fans events out to subscribers in this process. Publish accepts a
+// nil *Bus and drops the event, so publishers don't need to check for one.
+type Bus struct {
+	buffer int
+
+	// Publish holds mu too, so IDs reach every subscriber in order
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	seq    uint64
+
+	published atomic.Int64
+	dropped   atomic.Int64
+}
+
+// Default is the bus the repositories publish to, set in main.
+var Default *Bus
+
+// New creates a bus whose subscribers buffer up to buffer events
+// (DefaultBuffer if buffer <= 0).
+func New(buffer int) *Bus {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Bus{buffer: buffer, subs: make(map[*Subscription]struct{})}
+}
+
+// Publish sends e to every subscriber whose filter matches it. ID and
+// Time are set here.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.seq++
+	e.ID = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.published.Add(1)
+
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			// Slow consumer: losing events is better than blocking the
+			// request that published them
+			s.dropped.Add(1)
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe starts receiving the events matching f. The subscription must
+// be closed once done with.
+func (b *Bus) Subscribe(f Filter) *Subscription {
+	s := &Subscription{bus: b, filter: f, ch: make(chan Event, b.buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Close ends every subscription, e.g. on shutdown so that streaming
+// responses return. Later events are ignored.
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+func (b *Bus) Stats() Stats {
+	if b == nil {
+		return Stats{}
+	}
+
+	b.mu.Lock()
+	subscribers := len(b.subs)
+	b.mu.Unlock()
+
+	return Stats{
+		Subscribers: subscribers,
+		Published:   b.published.Load(),
+		Dropped:     b.dropped.Load(),
+	}
+}
+
+// Subscription receives the events of a bus.
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	ch      chan Event
+	dropped atomic.Int64
+}
+
+// Events is closed when the subscription or the bus is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns the number of events lost since the last call because
+// the subscriber was too slow, and resets it.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+func (s *Subscription) Close() {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
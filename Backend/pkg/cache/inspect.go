@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Inspector is implemented by the backends that can describe their own
+// contents, for the cache dashboard. It is not part of Cache since the
+// repositories never need it.
+type Inspector interface {
+	ServerStats(ctx context.Context) (ServerStats, error)
+	// Keys describes up to limit keys matching pattern (glob-style, as
+	// for SCAN MATCH), biggest first. Only the first limit keys found are
+	// inspected, so on a large keyspace these are a sample, not the
+	// biggest keys overall.
+	Keys(ctx context.Context, pattern string, limit int) ([]KeyInfo, error)
+}
+
+// ServerStats is what the backend itself reports. For Redis these are the
+// server-wide INFO counters, so they include other clients of the server.
+type ServerStats struct {
+	Backend string `json:"backend"`
+	Keys    int64  `json:"keys"`
+	// UsedMemory is in bytes. MaxMemory is 0 when there is no limit.
+	UsedMemory     int64  `json:"used_memory"`
+	MaxMemory      int64  `json:"max_memory"`
+	EvictionPolicy string `json:"eviction_policy,omitempty"`
+	KeyspaceHits   int64  `json:"keyspace_hits"`
+	KeyspaceMisses int64  `json:"keyspace_misses"`
+	EvictedKeys    int64  `json:"evicted_keys"`
+	ExpiredKeys    int64  `json:"expired_keys"`
+}
+
+// KeyInfo describes one key.
+type KeyInfo struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// TTLSeconds is -1 for a key without expiry, as returned by Redis.
+	TTLSeconds int64 `json:"ttl_seconds"`
+	// Size is the MEMORY USAGE estimate in bytes.
+	Size int64 `json:"size"`
+}
+
+// scanCount is the COUNT hint of each SCAN call.
+const scanCount = 500
+
+func (rc *RedisCache) ServerStats(ctx context.Context) (ServerStats, error) {
+	stats := ServerStats{Backend: "redis"}
+
+	// One section per INFO call, several are only accepted since Redis 7
+	pipe := rc.rdb.Pipeline()
+	memory := pipe.Info(ctx, "memory")
+	server := pipe.Info(ctx, "stats")
+	size := pipe.DBSize(ctx)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return stats, err
+	}
+	fields := parseInfo(memory.Val() + "\n" + server.Val())
+	stats.UsedMemory = infoInt(fields, "used_memory")
+	stats.MaxMemory = infoInt(fields, "maxmemory")
+	stats.EvictionPolicy = fields["maxmemory_policy"]
+	stats.KeyspaceHits = infoInt(fields, "keyspace_hits")
+	stats.KeyspaceMisses = infoInt(fields, "keyspace_misses")
+	stats.EvictedKeys = infoInt(fields, "evicted_keys")
+	stats.ExpiredKeys = infoInt(fields, "expired_keys")
+	stats.Keys = size.Val()
+	return stats, nil
+}
+
+func (rc *RedisCache) Keys(ctx context.Context, pattern string, limit int) ([]KeyInfo, error) {
+	seen := make(map[string]struct{})
+	var names []string
+	var cursor uint64
+	for {
+		batch, next, err := rc.rdb.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		// SCAN may return a key more than once
+		for _, name := range batch {
+			if _, ok := seen[name]; !ok && len(names) < limit {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+		cursor = next
+		if cursor == 0 || len(names) >= limit {
+			break
+		}
+	}
+
+	pipe := rc.rdb.Pipeline()
+	types := make([]*redis.StatusCmd, len(names))
+	ttls := make([]*redis.DurationCmd, len(names))
+	sizes := make([]*redis.IntCmd, len(names))
+	for i, name := range names {
+		types[i] = pipe.Type(ctx, name)
+		ttls[i] = pipe.PTTL(ctx, name)
+		sizes[i] = pipe.MemoryUsage(ctx, name)
+	}
+	// Errors are checked per command: a key may expire between SCAN and
+	// MEMORY USAGE, which then replies nil
+	_, _ = pipe.Exec(ctx)
+
+	keys := make([]KeyInfo, 0, len(names))
+	for i, name := range names {
+		if err := types[i].Err(); err != nil {
+			return nil, err
+		}
+		typ := types[i].Val()
+		if typ == "none" {
+			continue
+		}
+		keys = append(keys, KeyInfo{Key: name, Type: typ, TTLSeconds: ttlSeconds(ttls[i].Val()), Size: sizes[i].Val()})
+	}
+	return biggest(keys, limit), nil
+}
+
+func (mc *MemoryCache) ServerStats(ctx context.Context) (ServerStats, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	stats := ServerStats{Backend: "memory"}
+	now := time.Now()
+	for key, e := range mc.data {
+		if e.expired(now) {
+			continue
+		}
+		stats.Keys++
+		stats.UsedMemory += e.size(key)
+	}
+	return stats, nil
+}
+
+func (mc *MemoryCache) Keys(ctx context.Context, pattern string, limit int) ([]KeyInfo, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := time.Now()
+	var keys []KeyInfo
+	for key, e := range mc.data {
+		if e.expired(now) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); !ok {
+			continue
+		}
+		ttl := NoExpiry
+		if !e.expireAt.IsZero() {
+			ttl = e.expireAt.Sub(now)
+		}
+		keys = append(keys, KeyInfo{Key: key, Type: e.kind.String(), TTLSeconds: ttlSeconds(ttl), Size: e.size(key)})
+	}
+	return biggest(keys, limit), nil
+}
+
+func (k memKind) String() string {
+	switch k {
+	case memHash:
+		return "hash"
+	case memSet:
+		return "set"
+	default:
+		return "string"
+	}
+}
+
+// size approximates the bytes held by the entry: its key, fields, values
+// and members, without the overhead Redis would add.
+func (e *memEntry) size(key string) int64 {
+	n := len(key) + len(e.str)
+	for f, v := range e.hash {
+		n += len(f) + len(v)
+	}
+	for m := range e.set {
+		n += len(m)
+	}
+	return int64(n)
+}
+
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return int64(NoExpiry)
+	}
+	return int64(ttl / time.Second)
+}
+
+// biggest sorts keys by size, largest first, and keeps the first limit.
+func biggest(keys []KeyInfo, limit int) []KeyInfo {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Size != keys[j].Size {
+			return keys[i].Size > keys[j].Size
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// parseInfo reads the "field:value" lines of an INFO reply.
+func parseInfo(info string) map[string]string {
+	res := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		field, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			res[field] = value
+		}
+	}
+	return res
+}
+
+func infoInt(fields map[string]string, field string) int64 {
+	n, _ := strconv.ParseInt(fields[field], 10, 64)
+	return n
+}
@@ -0,0 +1,292 @@
+// Package invalidation keeps in-process caches coherent across instances.
+// Every write publishes the key it changed on a Redis Pub/Sub channel and
+// every other instance evicts that key from its local tier. Messages carry
+// the ID of the instance that sent them: the writer already updated its own
+// local tier and ignores its messages.
+//
+// Pub/Sub is fire-and-forget: messages sent while an instance is
+// disconnected are lost. The bus notices when its subscription was
+// re-established and asks subscribers to flush everything instead.
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultChannel is the channel used when none is configured.
+const DefaultChannel = "cache:invalidate"
+
+// pingInterval is how long the subscriber waits for a message before
+// pinging, so a dead connection is noticed even when nothing is published.
+const pingInterval = 30 * time.Second
+
+// Subscriber is what an instance does with invalidations.
+type Subscriber struct {
+	// Invalidate evicts one key.
+	Invalidate func(key string)
+	// Flush evicts everything, after invalidations may have been missed.
+	Flush func()
+}
+
+// Stats is a snapshot of the bus counters.
+type Stats struct {
+	Published int64 `json:"published"`
+	Received  int64 `json:"received"`
+	// Own counts the received messages this instance published, which it
+	// ignores.
+	Own int64 `json:"own"`
+	// Flushes counts full local flushes after a subscription gap.
+	Flushes    int64 `json:"flushes"`
+	Reconnects int64 `json:"reconnects"`
+	Connected  bool  `json:"connected"`
+}
+
+// Breaker guards the calls to Redis, see cache.Breaker.
+type Breaker interface {
+	Do(fn func() error) error
+	Record(err error)
+	Open() bool
+}
+
+// message is what is published on the channel.
+type message struct {
+	Origin string `json:"origin"`
+	Key    string `json:"key"`
+}
+
+// Bus publishes and receives key invalidations on one channel.
+type Bus struct {
+	rdb     *redis.Client
+	id      string
+	channel string
+	breaker Breaker
+
+	mu          sync.RWMutex
+	subscribers []Subscriber
+
+	published  atomic.Int64
+	received   atomic.Int64
+	own        atomic.Int64
+	flushes    atomic.Int64
+	reconnects atomic.Int64
+	connected  atomic.Bool
+}
+
+// Default is the bus used by the repositories, set in main. It is nil with
+// the in-memory cache backend, which has nothing to share.
+var Default *Bus
+
+func New(rdb *redis.Client, channel string) *Bus {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	return &Bus{rdb: rdb, channel: channel, id: instanceID()}
+}
+
+// instanceID names this process: the host and PID to read in logs, and a
+// random suffix in case two containers share both.
+func instanceID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// ID is the origin this bus puts in its messages.
+func (b *Bus) ID() string {
+	return b.id
+}
+
+// Guard routes publishes through br: they fail with its error while it is
+// open, and their failures count towards opening it. The subscription
+// reports the errors it gets and doesn't reconnect while br is open.
+func (b *Bus) Guard(br Breaker) {
+	b.breaker = br
+}
+
+// Subscribe registers s for every invalidation received from now on.
+func (b *Bus) Subscribe(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, s)
+}
+
+// Publish announces that keys changed. The other instances evict them; this
+// one is expected to have updated its own local tier. It is a no-op on a
+// nil bus.
+func (b *Bus) Publish(ctx context.Context, keys ...string) error {
+	if b == nil {
+		return nil
+	}
+	pipe := b.rdb.Pipeline()
+	for _, key := range keys {
+		payload, err := json.Marshal(message{Origin: b.id, Key: key})
+		if err != nil {
+			return err
+		}
+		pipe.Publish(ctx, b.channel, payload)
+	}
+	err := b.do(func() error {
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.published.Add(int64(len(keys)))
+	return nil
+}
+
+// Evict evicts keys from this instance's subscribers and publishes them for
+// the others, for callers that can't reach the local tier themselves. It is
+// a no-op on a nil bus.
+func (b *Bus) Evict(ctx context.Context, keys ...string) error {
+	if b == nil {
+		return nil
+	}
+	for _, key := range keys {
+		b.invalidate(key)
+	}
+	return b.Publish(ctx, keys...)
+}
+
+// Start subscribes to the channel and dispatches invalidations until ctx
+// is done.
+func (b *Bus) Start(ctx context.Context) {
+	go b.run(ctx)
+}
+
+func (b *Bus) run(ctx context.Context) {
+	pubsub := b.rdb.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	subscribed := false
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, pingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Nothing published for a while, make sure the connection
+				// is still alive; a failed ping reconnects on the next
+				// receive
+				_ = pubsub.Ping(ctx)
+				continue
+			}
+			if b.connected.Swap(false) {
+				log.Println("Invalidation bus disconnected:", err)
+			}
+			if b.breaker != nil {
+				b.breaker.Record(err)
+			}
+			// The next receive reconnects
+			if !b.pause(ctx) {
+				return
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind != "subscribe" {
+				continue
+			}
+			b.connected.Store(true)
+			if subscribed {
+				// Resubscribed after a reconnect: whatever was published
+				// in between is lost
+				b.reconnects.Add(1)
+				fmt.Println("Invalidation bus reconnected, flushing local cache")
+				b.flush()
+			}
+			subscribed = true
+		case *redis.Message:
+			b.received.Add(1)
+			var msg message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Println("Invalid invalidation message:", err)
+				continue
+			}
+			b.receive(msg)
+		}
+	}
+}
+
+// pause waits a second before reconnecting, and then for as long as the
+// breaker is open. It returns false once ctx is done.
+func (b *Bus) pause(ctx context.Context) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+		if b.breaker == nil || !b.breaker.Open() {
+			return true
+		}
+	}
+}
+
+// do runs a call to Redis through the breaker, if any.
+func (b *Bus) do(fn func() error) error {
+	if b.breaker == nil {
+		return fn()
+	}
+	return b.breaker.Do(fn)
+}
+
+// receive evicts the key of a message sent by another instance.
+func (b *Bus) receive(m message) {
+	if m.Origin == b.id {
+		b.own.Add(1)
+		return
+	}
+	b.invalidate(m.Key)
+}
+
+func (b *Bus) invalidate(key string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subscribers {
+		if s.Invalidate != nil {
+			s.Invalidate(key)
+		}
+	}
+}
+
+func (b *Bus) flush() {
+	b.flushes.Add(1)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subscribers {
+		if s.Flush != nil {
+			s.Flush()
+		}
+	}
+}
+
+func (b *Bus) Stats() Stats {
+	return Stats{
+		Published:  b.published.Load(),
+		Received:   b.received.Load(),
+		Own:        b.own.Load(),
+		Flushes:    b.flushes.Load(),
+		Reconnects: b.reconnects.Load(),
+		Connected:  b.connected.Load(),
+	}
+}
@@ -0,0 +1,24 @@
+package invalidation
+
+import "testing"
+
+func TestReceiveSkipsOwnMessages(t *testing.T) {
+	b := New(nil, "")
+	other := New(nil, "")
+	if b.ID() == other.ID() {
+		t.Fatalf("two buses share the ID %s", b.ID())
+	}
+
+	var evicted []string
+	b.Subscribe(Subscriber{Invalidate: func(key string) { evicted = append(evicted, key) }})
+
+	b.receive(message{Origin: b.ID(), Key: "product:1"})
+	b.receive(message{Origin: other.ID(), Key: "product:2"})
+
+	if len(evicted) != 1 || evicted[0] != "product:2" {
+		t.Errorf("evicted %v, want only the other instance's key", evicted)
+	}
+	if st := b.Stats(); st.Own != 1 {
+		t.Errorf("own = %d, want 1", st.Own)
+	}
+}
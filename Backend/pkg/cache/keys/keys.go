@@ -0,0 +1,168 @@
+// Package keys builds the cache keys, so their format lives in one place.
+//
+// Every key starts with the namespace of the application (and tenant), and
+// the keys of an entity carry the version of the schema they are stored
+// with:
+//
+//	<app>[:<tenant>]:<entity>:v<version>:<id>                 e.g. caching:product:v1:42
+//	<app>[:<tenant>]:<entity>:v<version>:all_ids              the ID index
+//	<app>[:<tenant>]:<entity>:v<version>:by_<column>:<value>  the ID of a unique value
+//	<app>[:<tenant>]:lock:<entity>:<id>                       update locks
+//	<app>[:<tenant>]:id_seq:<entity>                          the write-behind ID sequence
+//
+// Bumping the version when what is cached changes shape makes a deploy read
+// fresh keys instead of decoding entries written by the previous release.
+// The old keys are copied or dropped in the background by a Migrator.
+package keys
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// indexSuffix names the ID index of a family.
+const indexSuffix = "all_ids"
+
+// Namespace is the prefix shared by every key of one application and
+// tenant.
+type Namespace struct {
+	prefix string
+}
+
+// DefaultApp is the namespace used when none is configured.
+const DefaultApp = "caching"
+
+// Default is the namespace of this deployment, set in main.
+var Default = New(DefaultApp, "")
+
+// New returns the namespace of app, or of one tenant of app if tenant is
+// not empty.
+func New(app, tenant string) Namespace {
+	if tenant == "" {
+		return Namespace{prefix: app}
+	}
+	return Namespace{prefix: app + ":" + tenant}
+}
+
+func (n Namespace) String() string {
+	return n.prefix
+}
+
+// Key joins parts under the namespace, for keys that don't belong to a
+// family.
+func (n Namespace) Key(parts ...string) string {
+	return n.prefix + ":" + strings.Join(parts, ":")
+}
+
+// Family returns the keys of entity stored with schema version.
+func (n Namespace) Family(entity string, version int) Family {
+	return Family{
+		ns:      n,
+		entity:  entity,
+		version: version,
+		prefix:  n.Key(entity, "v"+strconv.Itoa(version)) + ":",
+	}
+}
+
+// Family is the set of keys of one entity at one schema version.
+type Family struct {
+	ns      Namespace
+	entity  string
+	version int
+	prefix  string
+	// index overrides the index key, see Unversioned
+	index string
+}
+
+// Unversioned describes keys written before the schema was versioned,
+// e.g. Unversioned("product", "product:", "products:all_ids"), so they can
+// be migrated. Version returns 0 for them.
+func Unversioned(entity, prefix, index string) Family {
+	return Family{entity: entity, prefix: prefix, index: index}
+}
+
+func (f Family) Entity() string {
+	return f.entity
+}
+
+func (f Family) Version() int {
+	return f.version
+}
+
+// WithVersion returns the keys of the same entity at another version.
+func (f Family) WithVersion(version int) Family {
+	return f.ns.Family(f.entity, version)
+}
+
+// Prefix is shared by every key of the family, e.g. for client tracking.
+func (f Family) Prefix() string {
+	return f.prefix
+}
+
+// Pattern matches every key of the family, for SCAN.
+func (f Family) Pattern() string {
+	return f.prefix + "*"
+}
+
+// ID returns the key of one entry.
+func (f Family) ID(id uint) string {
+	return f.prefix + strconv.FormatUint(uint64(id), 10)
+}
+
+// Index returns the key of the set listing the IDs of the entity.
+func (f Family) Index() string {
+	if f.index != "" {
+		return f.index
+	}
+	return f.prefix + indexSuffix
+}
+
+// Lookup returns the key mapping value, in a unique column, to the ID of
+// its entry.
+func (f Family) Lookup(column, value string) string {
+	return f.prefix + "by_" + column + ":" + value
+}
+
+// ParseID returns the ID of an entry key of the family.
+func (f Family) ParseID(key string) (uint, bool) {
+	rest, ok := strings.CutPrefix(key, f.prefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(rest, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (f Family) matches(key string) bool {
+	return strings.HasPrefix(key, f.prefix)
+}
+
+// Lock returns the key of the lock on one entry. It doesn't depend on the
+// version: instances of two releases must take the same lock.
+func (f Family) Lock(id uint) string {
+	return f.ns.Key("lock", f.entity, strconv.FormatUint(uint64(id), 10))
+}
+
+// IDSeq returns the key of the sequence write-behind creates take their ID
+// from. Like Lock, it doesn't depend on the version: instances of two
+// releases must not hand out the same ID.
+func (f Family) IDSeq() string {
+	return f.ns.Key("id_seq", f.entity)
+}
+
+// Lease returns the key of the fill lease of key, one of the family's
+// keys.
+func (f Family) Lease(key string) string {
+	return f.ns.Key("lease", strings.TrimPrefix(key, f.ns.prefix+":"))
+}
+
+func (f Family) String() string {
+	if f.ns.prefix == "" {
+		return fmt.Sprintf("%s (unversioned)", f.entity)
+	}
+	return fmt.Sprintf("%s v%d", f.entity, f.version)
+}
@@ -0,0 +1,306 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoMigrations is returned by Run when nothing was registered.
+var ErrNoMigrations = errors.New("no key migrations registered")
+
+// Transform converts an entry hash from the old schema to the new one.
+// Returning nil drops the entry instead: it is reloaded from the database
+// on its next read.
+type Transform func(h map[string]string) map[string]string
+
+// Migration moves the keys of From to To. Entries are copied, through
+// Transform if set, unless To already holds them; the ID index is dropped,
+// the next full read rebuilds it. A zero To only deletes the keys of From.
+type Migration struct {
+	From      Family
+	To        Family
+	Transform Transform
+}
+
+func (mig Migration) gcOnly() bool {
+	return mig.To.prefix == ""
+}
+
+// Report is the result of one pass over the keys of a migration.
+type Report struct {
+	From    string `json:"from"`
+	To      string `json:"to,omitempty"`
+	DryRun  bool   `json:"dry_run"`
+	Scanned int64  `json:"scanned"`
+	Copied  int64  `json:"copied"`
+	// Skipped counts entries To already had, written since the deploy.
+	Skipped int64 `json:"skipped"`
+	// Dropped counts entries and indexes deleted without being copied.
+	Dropped   int64     `json:"dropped"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+}
+
+// Moved is the number of old keys the pass removed.
+func (r Report) Moved() int64 {
+	return r.Copied + r.Skipped + r.Dropped
+}
+
+// MigrationStats accumulates the passes that weren't dry runs.
+type MigrationStats struct {
+	Runs    int64 `json:"runs"`
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Dropped int64 `json:"dropped"`
+	// LastRun has one report per migration.
+	LastRun   []Report `json:"last_run,omitempty"`
+	LastError string   `json:"last_error,omitempty"`
+}
+
+// Migrator moves the keys of old schema versions to the current ones, a
+// batch at a time so a large keyspace doesn't block Redis. Keys written by
+// instances still running the previous release during a rolling deploy are
+// picked up by the next pass.
+type Migrator struct {
+	rdb       *redis.Client
+	batchSize int
+
+	mu         sync.Mutex
+	migrations []Migration
+	stats      MigrationStats
+}
+
+// DefaultMigrator is the migrator the repositories register with, set in
+// main. It is nil without Redis: the in-memory backend starts empty.
+var DefaultMigrator *Migrator
+
+func NewMigrator(rdb *redis.Client, batchSize int) *Migrator {
+	return &Migrator{rdb: rdb, batchSize: batchSize}
+}
+
+func (m *Migrator) Register(migs ...Migration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrations = append(m.migrations, migs...)
+}
+
+// Run makes one pass over every migration. Only a pass that isn't a dry
+// run counts towards Stats.
+func (m *Migrator) Run(ctx context.Context, dryRun bool) ([]Report, error) {
+	m.mu.Lock()
+	migs := m.migrations
+	m.mu.Unlock()
+	if len(migs) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	reports := make([]Report, 0, len(migs))
+	var err error
+	for _, mig := range migs {
+		var report Report
+		report, err = m.migrate(ctx, mig, dryRun)
+		report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+		reports = append(reports, report)
+		if err != nil {
+			err = fmt.Errorf("migrating %s: %w", mig.From, err)
+			break
+		}
+	}
+	if dryRun {
+		return reports, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.stats.LastError = err.Error()
+		return reports, err
+	}
+	m.stats.Runs++
+	for _, r := range reports {
+		m.stats.Copied += r.Copied
+		m.stats.Skipped += r.Skipped
+		m.stats.Dropped += r.Dropped
+	}
+	m.stats.LastRun = reports
+	m.stats.LastError = ""
+	return reports, nil
+}
+
+// Start runs a pass now, then each interval until ctx is done.
+func (m *Migrator) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			reports, err := m.Run(ctx, false)
+			if err != nil {
+				log.Println("Key migration failed:", err)
+			}
+			for _, r := range reports {
+				if r.Moved() > 0 {
+					fmt.Printf("Key migration %s: %d copied, %d already migrated, %d dropped\n", r.From, r.Copied, r.Skipped, r.Dropped)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *Migrator) Stats() MigrationStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// migrateScript copies the hash built from KEYS[1] to KEYS[2], unless
+// KEYS[2] exists, keeping the remaining TTL, and deletes KEYS[1]. The
+// fields are passed as ARGV pairs. It returns 1 when the hash was copied,
+// 0 when KEYS[2] already existed and -1 when KEYS[1] is gone.
+var migrateScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return -1
+end
+local copied = 0
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("HSET", KEYS[2], unpack(ARGV))
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[2], ttl)
+	end
+	copied = 1
+end
+redis.call("DEL", KEYS[1])
+return copied
+`)
+
+func (m *Migrator) migrate(ctx context.Context, mig Migration, dryRun bool) (Report, error) {
+	report := Report{From: mig.From.String(), DryRun: dryRun, StartedAt: time.Now()}
+	if !mig.gcOnly() {
+		report.To = mig.To.String()
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := m.rdb.Scan(ctx, cursor, mig.From.Pattern(), int64(m.batchSize)).Result()
+		if err != nil {
+			return report, err
+		}
+		if err := m.migrateBatch(ctx, mig, keys, dryRun, &report); err != nil {
+			return report, err
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	// An unversioned index isn't under the family prefix
+	if index := mig.From.Index(); !mig.From.matches(index) {
+		if err := m.dropIndex(ctx, index, dryRun, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// migrateBatch moves the keys of one SCAN page. Keys of the family that
+// are neither entries nor the index are left alone.
+func (m *Migrator) migrateBatch(ctx context.Context, mig Migration, keys []string, dryRun bool, report *Report) error {
+	var entries []string
+	var ids []uint
+	for _, key := range keys {
+		if id, ok := mig.From.ParseID(key); ok {
+			entries = append(entries, key)
+			ids = append(ids, id)
+			continue
+		}
+		if key == mig.From.Index() {
+			if err := m.dropIndex(ctx, key, dryRun, report); err != nil {
+				return err
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	report.Scanned += int64(len(entries))
+
+	pipe := m.rdb.Pipeline()
+	hashes := make([]*redis.MapStringStringCmd, len(entries))
+	exists := make([]*redis.IntCmd, len(entries))
+	for i, key := range entries {
+		hashes[i] = pipe.HGetAll(ctx, key)
+		if !mig.gcOnly() {
+			exists[i] = pipe.Exists(ctx, mig.To.ID(ids[i]))
+		}
+	}
+	// Entries that aren't hashes fail on their own, checked below
+	_, _ = pipe.Exec(ctx)
+
+	var drop []string
+	for i, key := range entries {
+		h, err := hashes[i].Result()
+		if err != nil || len(h) == 0 {
+			continue
+		}
+		if !mig.gcOnly() && mig.Transform != nil {
+			h = mig.Transform(h)
+		}
+		if mig.gcOnly() || len(h) == 0 {
+			drop = append(drop, key)
+			continue
+		}
+
+		if dryRun {
+			if exists[i].Val() > 0 {
+				report.Skipped++
+			} else {
+				report.Copied++
+			}
+			continue
+		}
+		args := make([]any, 0, 2*len(h))
+		for field, value := range h {
+			args = append(args, field, value)
+		}
+		res, err := migrateScript.Run(ctx, m.rdb, []string{key, mig.To.ID(ids[i])}, args...).Int()
+		if err != nil {
+			return err
+		}
+		switch res {
+		case 1:
+			report.Copied++
+		case 0:
+			report.Skipped++
+		}
+	}
+
+	report.Dropped += int64(len(drop))
+	if dryRun || len(drop) == 0 {
+		return nil
+	}
+	return m.rdb.Unlink(ctx, drop...).Err()
+}
+
+func (m *Migrator) dropIndex(ctx context.Context, key string, dryRun bool, report *Report) error {
+	if dryRun {
+		n, err := m.rdb.Exists(ctx, key).Result()
+		report.Dropped += n
+		return err
+	}
+	n, err := m.rdb.Unlink(ctx, key).Result()
+	report.Dropped += n
+	return err
+}
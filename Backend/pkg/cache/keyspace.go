@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keyspace is the listener started by ConnectKeyspace, nil without Redis.
+var Keyspace *KeyspaceListener
+
+// Kinds of key events, named after the Redis notifications.
+const (
+	KeyEvicted = "evicted" // dropped by Redis because of maxmemory
+	KeyExpired = "expired" // its TTL ran out
+	KeyDeleted = "del"     // removed with DEL, also when a hash is replaced
+)
+
+// keyspaceFlags are the notify-keyspace-events classes the listener needs:
+// keyevent channels (E), generic commands such as DEL (g), expired (x)
+// and evicted (e) events.
+const keyspaceFlags = "Egxe"
+
+const (
+	// keyEventBatch and keyEventFlush bound how many events, and for how
+	// long, are collected before subscribers are called.
+	keyEventBatch = 256
+	keyEventFlush = 100 * time.Millisecond
+
+	// pingInterval is how long the subscription may stay silent before it
+	// is pinged, as for the invalidation bus.
+	pingInterval = 30 * time.Second
+)
+
+// KeyEvent is a key Redis removed on its own or was told to delete.
+type KeyEvent struct {
+	Kind string
+	Key  string
+}
+
+// KeyEventHandler is called with the key events received, in batches.
+type KeyEventHandler func(ctx context.Context, evs []KeyEvent)
+
+// KeyspaceStats is a snapshot of the listener counters.
+type KeyspaceStats struct {
+	// NotifyConfig is notify-keyspace-events as last read or set, empty
+	// if CONFIG is not available.
+	NotifyConfig string           `json:"notify_config"`
+	Connected    bool             `json:"connected"`
+	Received     map[string]int64 `json:"received"`
+	Reconnects   int64            `json:"reconnects"`
+}
+
+// KeyspaceListener receives the keyevent notifications of one Redis
+// database. Like all Pub/Sub, notifications sent while it is disconnected
+// are lost.
+type KeyspaceListener struct {
+	rdb       *redis.Client
+	configure bool
+	breaker   *Breaker
+
+	mu       sync.RWMutex
+	handlers []KeyEventHandler
+	config   string
+
+	evicted    atomic.Int64
+	expired    atomic.Int64
+	deleted    atomic.Int64
+	reconnects atomic.Int64
+	connected  atomic.Bool
+}
+
+// NewKeyspaceListener creates a listener on rdb. With configure, it turns
+// on the notifications it needs (CONFIG SET notify-keyspace-events) when
+// they are off, which Redis doesn't do by default.
+func NewKeyspaceListener(rdb *redis.Client, configure bool) *KeyspaceListener {
+	return &KeyspaceListener{rdb: rdb, configure: configure}
+}
+
+// ConnectKeyspace starts a listener on Rdb and sets Keyspace. An error
+// means the notifications are off and couldn't be turned on: the listener
+// still runs, in case they are enabled on the server later.
+func ConnectKeyspace(ctx context.Context, configure bool) error {
+	if Rdb == nil {
+		return errors.New("keyspace notifications need the Redis cache backend")
+	}
+	l := NewKeyspaceListener(Rdb, configure)
+	l.Guard(StoreBreaker)
+	err := l.ensureConfig(ctx)
+	l.Start(ctx)
+	Keyspace = l
+	return err
+}
+
+// Guard routes the CONFIG calls through b, and reports the errors of the
+// subscription to it. The subscription doesn't reconnect while b is open.
+func (l *KeyspaceListener) Guard(b *Breaker) {
+	l.breaker = b
+}
+
+// Subscribe registers h for every key event received from now on.
+func (l *KeyspaceListener) Subscribe(h KeyEventHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers = append(l.handlers, h)
+}
+
+// ensureConfig checks that the server sends the notifications, and turns
+// them on if allowed. Managed Redis services often disable CONFIG; the
+// notifications then have to be enabled in the service settings.
+func (l *KeyspaceListener) ensureConfig(ctx context.Context) error {
+	var res map[string]string
+	err := l.breaker.Do(func() (err error) {
+		res, err = l.rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("can't read notify-keyspace-events: %w", err)
+	}
+	current := res["notify-keyspace-events"]
+	l.setConfig(current)
+
+	missing := missingNotifyFlags(current)
+	if missing == "" {
+		return nil
+	}
+	if !l.configure {
+		return fmt.Errorf("notify-keyspace-events=%q lacks %q", current, missing)
+	}
+	wanted := current + missing
+	err = l.breaker.Do(func() error {
+		return l.rdb.ConfigSet(ctx, "notify-keyspace-events", wanted).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("can't set notify-keyspace-events=%q: %w", wanted, err)
+	}
+	l.setConfig(wanted)
+	return nil
+}
+
+// missingNotifyFlags returns the keyspaceFlags flags lacks. "A" stands for
+// every event class.
+func missingNotifyFlags(flags string) string {
+	var missing strings.Builder
+	for _, f := range keyspaceFlags {
+		if strings.ContainsRune(flags, f) || (f != 'E' && strings.ContainsRune(flags, 'A')) {
+			continue
+		}
+		missing.WriteRune(f)
+	}
+	return missing.String()
+}
+
+func (l *KeyspaceListener) setConfig(config string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+}
+
+// Start subscribes to the notifications and dispatches them until ctx is
+// done.
+func (l *KeyspaceListener) Start(ctx context.Context) {
+	go l.run(ctx)
+}
+
+func (l *KeyspaceListener) run(ctx context.Context) {
+	prefix := fmt.Sprintf("__keyevent@%d__:", l.rdb.Options().DB)
+	pubsub := l.rdb.Subscribe(ctx, prefix+KeyEvicted, prefix+KeyExpired, prefix+KeyDeleted)
+	defer pubsub.Close()
+
+	var batch []KeyEvent
+	subscribed := false
+	for {
+		// Wait for more events only briefly once some are collected
+		timeout := pingInterval
+		if len(batch) > 0 {
+			timeout = keyEventFlush
+		}
+		msg, err := pubsub.ReceiveTimeout(ctx, timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if len(batch) > 0 {
+					l.dispatch(ctx, batch)
+					batch = nil
+					continue
+				}
+				_ = pubsub.Ping(ctx)
+				continue
+			}
+			if l.connected.Swap(false) {
+				log.Println("Keyspace notifications disconnected:", err)
+			}
+			l.breaker.Record(err)
+			// The next receive reconnects
+			if !l.pause(ctx) {
+				return
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind != "subscribe" || l.connected.Load() {
+				continue
+			}
+			l.connected.Store(true)
+			if subscribed {
+				// A restarted server has lost a CONFIG SET
+				l.reconnects.Add(1)
+				if err := l.ensureConfig(ctx); err != nil {
+					log.Println("Keyspace notifications:", err)
+				}
+			}
+			subscribed = true
+		case *redis.Message:
+			kind := strings.TrimPrefix(m.Channel, prefix)
+			l.count(kind)
+			batch = append(batch, KeyEvent{Kind: kind, Key: m.Payload})
+			if len(batch) >= keyEventBatch {
+				l.dispatch(ctx, batch)
+				batch = nil
+			}
+		}
+	}
+}
+
+// pause waits a second before reconnecting, and then for as long as the
+// breaker is open. It returns false once ctx is done.
+func (l *KeyspaceListener) pause(ctx context.Context) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+		if !l.breaker.Open() {
+			return true
+		}
+	}
+}
+
+func (l *KeyspaceListener) count(kind string) {
+	switch kind {
+	case KeyEvicted:
+		l.evicted.Add(1)
+	case KeyExpired:
+		l.expired.Add(1)
+	case KeyDeleted:
+		l.deleted.Add(1)
+	}
+}
+
+func (l *KeyspaceListener) dispatch(ctx context.Context, evs []KeyEvent) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, h := range l.handlers {
+		h(ctx, evs)
+	}
+}
+
+func (l *KeyspaceListener) Stats() KeyspaceStats {
+	l.mu.RLock()
+	config := l.config
+	l.mu.RUnlock()
+
+	return KeyspaceStats{
+		NotifyConfig: config,
+		Connected:    l.connected.Load(),
+		Received: map[string]int64{
+			KeyEvicted: l.evicted.Load(),
+			KeyExpired: l.expired.Load(),
+			KeyDeleted: l.deleted.Load(),
+		},
+		Reconnects: l.reconnects.Load(),
+	}
+}
@@ -0,0 +1,111 @@
+package local
+
+// arc is the Adaptive Replacement Cache. Resident keys live in t1 (seen
+// once recently) or t2 (seen at least twice); b1 and b2 remember keys
+// recently evicted from each. A hit on a ghost tells ARC which list it
+// shrank too eagerly, and the target size p of t1 moves accordingly.
+//
+// The cache is bounded by bytes rather than entries, so the capacity ARC
+// adapts against is the number of keys currently resident.
+type arc struct {
+	t1, t2, b1, b2 *keyList
+	p              int
+	// set when the last insert was a b2 ghost hit, which biases the next
+	// eviction towards t1 as in the original algorithm
+	lastGhostB2 bool
+}
+
+func newARC() *arc {
+	return &arc{t1: newKeyList(), t2: newKeyList(), b1: newKeyList(), b2: newKeyList()}
+}
+
+func (p *arc) capacity() int {
+	c := p.t1.len() + p.t2.len()
+	if c < 1 {
+		c = 1
+	}
+	return c
+}
+
+func (p *arc) Add(key string) {
+	if p.t1.contains(key) || p.t2.contains(key) {
+		p.Access(key)
+		return
+	}
+
+	c := p.capacity()
+	p.lastGhostB2 = false
+	switch {
+	case p.b1.contains(key):
+		delta := 1
+		if p.b1.len() > 0 && p.b2.len() > p.b1.len() {
+			delta = p.b2.len() / p.b1.len()
+		}
+		p.p = min(p.p+delta, c)
+		p.b1.remove(key)
+		p.t2.pushFront(key)
+	case p.b2.contains(key):
+		delta := 1
+		if p.b2.len() > 0 && p.b1.len() > p.b2.len() {
+			delta = p.b1.len() / p.b2.len()
+		}
+		p.p = max(p.p-delta, 0)
+		p.b2.remove(key)
+		p.t2.pushFront(key)
+		p.lastGhostB2 = true
+	default:
+		p.t1.pushFront(key)
+	}
+}
+
+func (p *arc) Access(key string) {
+	if p.t1.remove(key) {
+		p.t2.pushFront(key)
+		return
+	}
+	p.t2.moveToFront(key)
+}
+
+func (p *arc) Remove(key string) {
+	p.t1.remove(key)
+	p.t2.remove(key)
+	p.b1.remove(key)
+	p.b2.remove(key)
+}
+
+func (p *arc) Evict() (string, bool) {
+	var key string
+	var ok bool
+	t1 := p.t1.len()
+	if t1 > 0 && (t1 > p.p || (p.lastGhostB2 && t1 == p.p) || p.t2.len() == 0) {
+		key, ok = p.t1.popBack()
+		p.b1.pushFront(key)
+	} else {
+		key, ok = p.t2.popBack()
+		if ok {
+			p.b2.pushFront(key)
+		}
+	}
+	p.trimGhosts()
+	return key, ok
+}
+
+// trimGhosts keeps each ghost list no longer than the resident set.
+func (p *arc) trimGhosts() {
+	c := p.capacity()
+	for p.b1.len() > c {
+		p.b1.popBack()
+	}
+	for p.b2.len() > c {
+		p.b2.popBack()
+	}
+}
+
+func (p *arc) Reset() {
+	p.t1.reset()
+	p.t2.reset()
+	p.b1.reset()
+	p.b2.reset()
+	p.p = 0
+	p.lastGhostB2 = false
+}
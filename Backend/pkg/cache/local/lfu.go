@@ -0,0 +1,97 @@
+package local
+
+// lfu evicts the least frequently used key, breaking ties by recency.
+// Keys are grouped in one recency list per frequency so every operation is
+// O(1).
+type lfu struct {
+	freq    map[string]int
+	buckets map[int]*keyList
+	minFreq int
+}
+
+func newLFU() *lfu {
+	return &lfu{freq: make(map[string]int), buckets: make(map[int]*keyList)}
+}
+
+func (p *lfu) bucket(f int) *keyList {
+	b, ok := p.buckets[f]
+	if !ok {
+		b = newKeyList()
+		p.buckets[f] = b
+	}
+	return b
+}
+
+func (p *lfu) Add(key string) {
+	if _, ok := p.freq[key]; ok {
+		p.Access(key)
+		return
+	}
+	p.freq[key] = 1
+	p.bucket(1).pushFront(key)
+	p.minFreq = 1
+}
+
+func (p *lfu) Access(key string) {
+	f, ok := p.freq[key]
+	if !ok {
+		return
+	}
+	b := p.buckets[f]
+	b.remove(key)
+	if b.len() == 0 {
+		delete(p.buckets, f)
+		if p.minFreq == f {
+			p.minFreq = f + 1
+		}
+	}
+	p.freq[key] = f + 1
+	p.bucket(f + 1).pushFront(key)
+}
+
+func (p *lfu) Remove(key string) {
+	f, ok := p.freq[key]
+	if !ok {
+		return
+	}
+	delete(p.freq, key)
+	b := p.buckets[f]
+	b.remove(key)
+	if b.len() == 0 {
+		delete(p.buckets, f)
+		if p.minFreq == f {
+			p.recomputeMin()
+		}
+	}
+}
+
+func (p *lfu) Evict() (string, bool) {
+	if len(p.freq) == 0 {
+		return "", false
+	}
+	b, ok := p.buckets[p.minFreq]
+	if !ok {
+		p.recomputeMin()
+		b = p.buckets[p.minFreq]
+	}
+	key, _ := b.back()
+	p.Remove(key)
+	return key, true
+}
+
+// recomputeMin is only needed after a removal emptied the lowest bucket;
+// inserts and hits keep minFreq up to date on their own.
+func (p *lfu) recomputeMin() {
+	p.minFreq = 0
+	for f := range p.buckets {
+		if p.minFreq == 0 || f < p.minFreq {
+			p.minFreq = f
+		}
+	}
+}
+
+func (p *lfu) Reset() {
+	p.freq = make(map[string]int)
+	p.buckets = make(map[int]*keyList)
+	p.minFreq = 0
+}
@@ -0,0 +1,229 @@
+// Package local is a bounded in-process cache used as an L1 tier in front
+// of Redis. Reads never leave the process, so a hit costs a map lookup.
+//
+// The cache is bounded by a memory budget in bytes; when an insert goes
+// over it, the configured eviction Policy (LRU, LFU, ARC or W-TinyLFU)
+// chooses what to drop.
+package local
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sizer estimates how many bytes an entry occupies.
+type Sizer[V any] func(key string, value V) int64
+
+// entryOverhead approximates the map slot, list node and expiry time that
+// every entry costs on top of its key and value.
+const entryOverhead = 96
+
+type entry[V any] struct {
+	value    V
+	size     int64
+	expireAt time.Time // zero when the entry doesn't expire
+}
+
+// Options configures a Cache.
+type Options[V any] struct {
+	// MaxBytes is the memory budget. Zero means unbounded.
+	MaxBytes int64
+	// TTL is how long an entry lives after it is set. Zero means entries
+	// only leave on eviction or Delete.
+	TTL time.Duration
+	// Policy is one of Policies, "lru" by default.
+	Policy string
+	// Sizer estimates entry sizes. By default only the key and the fixed
+	// per-entry overhead are counted.
+	Sizer Sizer[V]
+	// OnEvict, if set, is called with every key evicted to stay within
+	// MaxBytes. It runs with the cache locked and must not use the cache.
+	OnEvict func(key string)
+}
+
+// Cache is a memory-bounded cache with an optional TTL on every entry.
+// It is safe for concurrent use.
+type Cache[V any] struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	sizer    Sizer[V]
+	onEvict  func(key string)
+	policy   Policy
+	misser   missRecorder // policy, if it records misses
+	name     string
+	items    map[string]*entry[V]
+	used     int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+	expired   atomic.Int64
+	rejected  atomic.Int64
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Policy    string `json:"policy"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+	Expired   int64  `json:"expired"`
+	// Rejected counts inserts refused by admission (W-TinyLFU) or because
+	// the entry alone is larger than the budget.
+	Rejected int64 `json:"rejected"`
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// New creates a cache from opts. It fails only on an unknown policy name.
+func New[V any](opts Options[V]) (*Cache[V], error) {
+	name := opts.Policy
+	if name == "" {
+		name = PolicyLRU
+	}
+	policy, err := NewPolicy(name)
+	if err != nil {
+		return nil, err
+	}
+	sizer := opts.Sizer
+	if sizer == nil {
+		sizer = func(key string, _ V) int64 { return int64(len(key)) }
+	}
+	misser, _ := policy.(missRecorder)
+	return &Cache[V]{
+		maxBytes: opts.MaxBytes,
+		ttl:      opts.TTL,
+		sizer:    sizer,
+		onEvict:  opts.OnEvict,
+		policy:   policy,
+		misser:   misser,
+		name:     name,
+		items:    make(map[string]*entry[V]),
+	}, nil
+}
+
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	e, ok := c.items[key]
+	if !ok {
+		c.miss(key)
+		return zero, false
+	}
+	if !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+		c.remove(key, e)
+		c.expired.Add(1)
+		c.miss(key)
+		return zero, false
+	}
+	c.policy.Access(key)
+	c.hits.Add(1)
+	return e.value, true
+}
+
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := entryOverhead + c.sizer(key, value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		c.rejected.Add(1)
+		return
+	}
+
+	var expireAt time.Time
+	if c.ttl > 0 {
+		expireAt = time.Now().Add(c.ttl)
+	}
+	if e, ok := c.items[key]; ok {
+		c.used += size - e.size
+		e.value, e.size, e.expireAt = value, size, expireAt
+		c.policy.Access(key)
+	} else {
+		c.items[key] = &entry[V]{value: value, size: size, expireAt: expireAt}
+		c.used += size
+		c.policy.Add(key)
+	}
+
+	for c.maxBytes > 0 && c.used > c.maxBytes {
+		victim, ok := c.policy.Evict()
+		if !ok {
+			break
+		}
+		if e, ok := c.items[victim]; ok {
+			delete(c.items, victim)
+			c.used -= e.size
+		}
+		if victim == key {
+			c.rejected.Add(1)
+		} else {
+			c.evictions.Add(1)
+			if c.onEvict != nil {
+				c.onEvict(victim)
+			}
+		}
+	}
+}
+
+func (c *Cache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(key, e)
+	}
+}
+
+// Purge drops every entry.
+func (c *Cache[V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*entry[V])
+	c.used = 0
+	c.policy.Reset()
+}
+
+func (c *Cache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *Cache[V]) Stats() Stats {
+	c.mu.Lock()
+	entries, used := len(c.items), c.used
+	c.mu.Unlock()
+
+	return Stats{
+		Policy:    c.name,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Expired:   c.expired.Load(),
+		Rejected:  c.rejected.Load(),
+		Entries:   entries,
+		Bytes:     used,
+		MaxBytes:  c.maxBytes,
+	}
+}
+
+// Callers must hold c.mu.
+func (c *Cache[V]) miss(key string) {
+	c.misses.Add(1)
+	if c.misser != nil {
+		c.misser.Miss(key)
+	}
+}
+
+// Callers must hold c.mu.
+func (c *Cache[V]) remove(key string, e *entry[V]) {
+	delete(c.items, key)
+	c.used -= e.size
+	c.policy.Remove(key)
+}
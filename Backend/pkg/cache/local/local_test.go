@@ -0,0 +1,112 @@
+package local
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheRecordsMissesInTinyLFU(t *testing.T) {
+	// Room for two one-byte keys
+	c, err := New(Options[int]{MaxBytes: 2 * (entryOverhead + 1), TTL: time.Hour, Policy: PolicyTinyLFU})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	for range 3 {
+		c.Get("d")
+	}
+	// d was looked up more than a, so it is admitted in its place
+	c.Set("d", 4)
+
+	if _, ok := c.Get("d"); !ok {
+		t.Error("d was rejected")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("a was kept over d")
+	}
+	if st := c.Stats(); st.Evictions != 1 || st.Rejected != 0 {
+		t.Errorf("stats = %+v, want one eviction", st)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	for _, tt := range []struct {
+		ttl  time.Duration
+		kept bool
+	}{
+		{ttl: 0, kept: true},
+		{ttl: time.Hour, kept: true},
+		{ttl: time.Nanosecond},
+	} {
+		c, _ := New(Options[int]{TTL: tt.ttl})
+		c.Set("a", 1)
+		time.Sleep(time.Millisecond)
+		if _, ok := c.Get("a"); ok != tt.kept {
+			t.Errorf("TTL %v: found = %v, want %v", tt.ttl, ok, tt.kept)
+		}
+	}
+}
+
+func TestCacheBudget(t *testing.T) {
+	for _, policy := range Policies {
+		t.Run(policy, func(t *testing.T) {
+			budget := int64(10 * (entryOverhead + 2))
+			var evicted int
+			c, _ := New(Options[int]{MaxBytes: budget, TTL: time.Hour, Policy: policy, OnEvict: func(string) { evicted++ }})
+			for i := range 100 {
+				c.Set(strconv.Itoa(i+10), i)
+			}
+			st := c.Stats()
+			if st.Bytes > budget || st.Entries > 10 {
+				t.Errorf("stats = %+v, over the budget of %d", st, budget)
+			}
+			if int64(evicted) != st.Evictions {
+				t.Errorf("OnEvict called %d times, %d evictions", evicted, st.Evictions)
+			}
+		})
+	}
+}
+
+// zipfTrace returns n keys out of keys distinct ones, drawn from a Zipf
+// distribution with exponent s: a few keys take most of the lookups, like
+// the product pages of a catalog.
+func zipfTrace(n, keys int, s float64) []string {
+	z := rand.NewZipf(rand.New(rand.NewSource(1)), s, 1, uint64(keys-1))
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = "product:" + strconv.FormatUint(z.Uint64(), 10)
+	}
+	return trace
+}
+
+// BenchmarkZipfHitRatio replays a Zipf trace through each policy, filling
+// the cache on every miss, with a budget holding 1% of the keys. It
+// reports the hit ratio next to the time per lookup.
+func BenchmarkZipfHitRatio(b *testing.B) {
+	const keys = 100_000
+	trace := zipfTrace(1_000_000, keys, 1.1)
+	size := func(key string, _ int) int64 { return int64(len(key)) }
+	budget := int64(keys / 100 * (entryOverhead + len("product:00000")))
+
+	for _, policy := range Policies {
+		b.Run(policy, func(b *testing.B) {
+			c, err := New(Options[int]{MaxBytes: budget, TTL: time.Hour, Policy: policy, Sizer: size})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				key := trace[i%len(trace)]
+				if _, ok := c.Get(key); !ok {
+					c.Set(key, i)
+				}
+			}
+			st := c.Stats()
+			b.ReportMetric(float64(st.Hits)/float64(st.Hits+st.Misses), "hit-ratio")
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package local
+
+import "container/list"
+
+// keyList is a recency list of keys with O(1) lookup, shared by the
+// policies that need one.
+type keyList struct {
+	ll    *list.List
+	elems map[string]*list.Element
+}
+
+func newKeyList() *keyList {
+	return &keyList{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (kl *keyList) contains(key string) bool {
+	_, ok := kl.elems[key]
+	return ok
+}
+
+func (kl *keyList) pushFront(key string) {
+	kl.elems[key] = kl.ll.PushFront(key)
+}
+
+func (kl *keyList) moveToFront(key string) {
+	if el, ok := kl.elems[key]; ok {
+		kl.ll.MoveToFront(el)
+	}
+}
+
+func (kl *keyList) remove(key string) bool {
+	el, ok := kl.elems[key]
+	if !ok {
+		return false
+	}
+	kl.ll.Remove(el)
+	delete(kl.elems, key)
+	return true
+}
+
+// popBack removes and returns the least recently used key.
+func (kl *keyList) popBack() (string, bool) {
+	el := kl.ll.Back()
+	if el == nil {
+		return "", false
+	}
+	key := el.Value.(string)
+	kl.ll.Remove(el)
+	delete(kl.elems, key)
+	return key, true
+}
+
+func (kl *keyList) back() (string, bool) {
+	el := kl.ll.Back()
+	if el == nil {
+		return "", false
+	}
+	return el.Value.(string), true
+}
+
+func (kl *keyList) len() int {
+	return kl.ll.Len()
+}
+
+func (kl *keyList) reset() {
+	kl.ll.Init()
+	kl.elems = make(map[string]*list.Element)
+}
+
+// lru evicts the least recently used key.
+type lru struct {
+	keys *keyList
+}
+
+func newLRU() *lru {
+	return &lru{keys: newKeyList()}
+}
+
+func (p *lru) Add(key string) {
+	if p.keys.contains(key) {
+		p.keys.moveToFront(key)
+		return
+	}
+	p.keys.pushFront(key)
+}
+
+func (p *lru) Access(key string) {
+	p.keys.moveToFront(key)
+}
+
+func (p *lru) Remove(key string) {
+	p.keys.remove(key)
+}
+
+func (p *lru) Evict() (string, bool) {
+	return p.keys.popBack()
+}
+
+func (p *lru) Reset() {
+	p.keys.reset()
+}
@@ -0,0 +1,58 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy decides which key leaves the cache when it is over its memory
+// budget. The cache owns the values and their sizes; a policy only tracks
+// keys. Policies are not safe for concurrent use, the cache serialises calls.
+type Policy interface {
+	// Add records a key that was just inserted.
+	Add(key string)
+	// Access records a hit on a resident key.
+	Access(key string)
+	// Remove forgets a key that was deleted or expired.
+	Remove(key string)
+	// Evict picks a resident key to drop and forgets it. It returns false
+	// when there is nothing left to evict.
+	Evict() (string, bool)
+	// Reset forgets every key.
+	Reset()
+}
+
+// missRecorder is implemented by the policies that also learn from lookups
+// of keys that aren't resident: W-TinyLFU counts them in its frequency
+// sketch, so a key missed often is admitted over one that was inserted once.
+type missRecorder interface {
+	// Miss records a lookup of a key that isn't resident.
+	Miss(key string)
+}
+
+// Policy names accepted by NewPolicy.
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyARC     = "arc"
+	PolicyTinyLFU = "w-tinylfu"
+)
+
+// Policies lists the available policy names.
+var Policies = []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU}
+
+// NewPolicy builds a policy from its name (case insensitive).
+func NewPolicy(name string) (Policy, error) {
+	switch strings.ToLower(name) {
+	case PolicyLRU:
+		return newLRU(), nil
+	case PolicyLFU:
+		return newLFU(), nil
+	case PolicyARC:
+		return newARC(), nil
+	case PolicyTinyLFU, "tinylfu":
+		return newTinyLFU(), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q (expected one of %s)", name, strings.Join(Policies, ", "))
+	}
+}
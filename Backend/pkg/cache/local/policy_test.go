@@ -0,0 +1,145 @@
+package local
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// replay applies ops to p, each "add k", "get k" (a hit), "miss k", "del k"
+// or "evict", then evicts until p is empty. It returns every key evicted,
+// in order.
+func replay(t *testing.T, p Policy, ops []string) []string {
+	t.Helper()
+	var evicted []string
+	for _, op := range ops {
+		verb, key, _ := strings.Cut(op, " ")
+		switch verb {
+		case "add":
+			p.Add(key)
+		case "get":
+			p.Access(key)
+		case "miss":
+			if m, ok := p.(missRecorder); ok {
+				m.Miss(key)
+			}
+		case "del":
+			p.Remove(key)
+		case "evict":
+			key, _ := p.Evict()
+			evicted = append(evicted, key)
+		default:
+			t.Fatalf("unknown op %q", op)
+		}
+	}
+
+	for {
+		key, ok := p.Evict()
+		if !ok {
+			return evicted
+		}
+		evicted = append(evicted, key)
+	}
+}
+
+func TestPolicyEvictionOrder(t *testing.T) {
+	tests := []struct {
+		policy string
+		name   string
+		ops    []string
+		want   []string
+	}{
+		{
+			policy: PolicyLRU, name: "least recently used first",
+			ops:  []string{"add a", "add b", "add c", "get a"},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			policy: PolicyLRU, name: "re-adding refreshes",
+			ops:  []string{"add a", "add b", "add a", "add c", "del b"},
+			want: []string{"a", "c"},
+		},
+		{
+			policy: PolicyLFU, name: "least frequently used first",
+			ops:  []string{"add a", "add b", "add c", "get a", "get a", "get c"},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			policy: PolicyLFU, name: "ties broken by recency",
+			ops:  []string{"add a", "add b", "add c", "add d", "get c"},
+			want: []string{"a", "b", "d", "c"},
+		},
+		{
+			policy: PolicyLFU, name: "removing the rarest key",
+			ops:  []string{"add a", "add b", "get b", "del a", "add c", "get c", "get c"},
+			want: []string{"b", "c"},
+		},
+		{
+			// A key hit twice moves to t2 and outlives a scan
+			policy: PolicyARC, name: "frequent keys survive a scan",
+			ops:  []string{"add a", "get a", "add b", "add c", "add d"},
+			want: []string{"b", "c", "d", "a"},
+		},
+		{
+			// Re-adding a key evicted from t1 (a b1 ghost) grows t1's
+			// target, so t2 is evicted from first
+			policy: PolicyARC, name: "ghost hit adapts",
+			ops:  []string{"add a", "add b", "get b", "evict", "add a", "add c"},
+			want: []string{"a", "b", "a", "c"},
+		},
+		{
+			// The scanned key is rejected by admission, the hot one stays
+			policy: PolicyTinyLFU, name: "popular keys survive a scan",
+			ops:  []string{"miss hot", "miss hot", "miss hot", "add hot", "add x"},
+			want: []string{"x", "hot"},
+		},
+		{
+			// c was only looked up, never hit: the misses alone get it
+			// admitted over a
+			policy: PolicyTinyLFU, name: "misses count towards admission",
+			ops:  []string{"add a", "add b", "miss c", "miss c", "miss c", "add c"},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			policy: PolicyTinyLFU, name: "probation before protected",
+			ops:  []string{"add a", "add b", "get a", "add c"},
+			want: []string{"c", "b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := replay(t, p, tt.ops)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("evicted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyReset(t *testing.T) {
+	for _, name := range Policies {
+		t.Run(name, func(t *testing.T) {
+			p, _ := NewPolicy(name)
+			p.Add("a")
+			p.Add("b")
+			p.Access("a")
+			p.Reset()
+			if key, ok := p.Evict(); ok {
+				t.Errorf("evicted %q after Reset", key)
+			}
+		})
+	}
+}
+
+func TestNewPolicyUnknown(t *testing.T) {
+	if _, err := NewPolicy("fifo"); err == nil {
+		t.Error("NewPolicy(fifo) succeeded")
+	}
+	if _, err := NewPolicy("TinyLFU"); err != nil {
+		t.Errorf("NewPolicy(TinyLFU) = %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package local
+
+import "hash/maphash"
+
+// tinyLFU is W-TinyLFU: new keys enter a small LRU window, and a key leaving
+// the window only displaces a key of the main segmented LRU when a
+// frequency sketch says it is used more often. This keeps one-off scans from
+// flushing the hot set, which plain LRU cannot do.
+//
+// The sketch counts lookups, hits and misses alike: a key is admitted for
+// how often it is asked for, not for how often it was inserted.
+type tinyLFU struct {
+	window    *keyList
+	probation *keyList
+	protected *keyList
+	sketch    *countMinSketch
+}
+
+const (
+	// share of resident keys kept in the admission window
+	windowPercent = 1
+	// share of the main segment reserved for keys hit more than once
+	protectedPercent = 80
+)
+
+func newTinyLFU() *tinyLFU {
+	return &tinyLFU{
+		window:    newKeyList(),
+		probation: newKeyList(),
+		protected: newKeyList(),
+		sketch:    newCountMinSketch(4096),
+	}
+}
+
+func (p *tinyLFU) resident() int {
+	return p.window.len() + p.probation.len() + p.protected.len()
+}
+
+func (p *tinyLFU) contains(key string) bool {
+	return p.window.contains(key) || p.probation.contains(key) || p.protected.contains(key)
+}
+
+func (p *tinyLFU) Add(key string) {
+	if p.contains(key) {
+		p.Access(key)
+		return
+	}
+	p.window.pushFront(key)
+
+	windowCap := max(1, p.resident()*windowPercent/100)
+	for p.window.len() > windowCap {
+		k, _ := p.window.popBack()
+		p.probation.pushFront(k)
+	}
+}
+
+func (p *tinyLFU) Miss(key string) {
+	p.sketch.increment(key)
+}
+
+func (p *tinyLFU) Access(key string) {
+	p.sketch.increment(key)
+	switch {
+	case p.window.contains(key):
+		p.window.moveToFront(key)
+	case p.probation.remove(key):
+		p.protected.pushFront(key)
+		protectedCap := max(1, (p.probation.len()+p.protected.len())*protectedPercent/100)
+		for p.protected.len() > protectedCap {
+			k, _ := p.protected.popBack()
+			p.probation.pushFront(k)
+		}
+	default:
+		p.protected.moveToFront(key)
+	}
+}
+
+func (p *tinyLFU) Remove(key string) {
+	if !p.window.remove(key) && !p.probation.remove(key) {
+		p.protected.remove(key)
+	}
+}
+
+func (p *tinyLFU) Evict() (string, bool) {
+	main := p.probation
+	if main.len() == 0 {
+		main = p.protected
+	}
+
+	candidate, hasCandidate := p.window.back()
+	victim, hasVictim := main.back()
+	switch {
+	case hasCandidate && hasVictim:
+		// Admission: the window's oldest key replaces the main segment's
+		// oldest key only if it is more popular.
+		if p.sketch.estimate(candidate) > p.sketch.estimate(victim) {
+			main.remove(victim)
+			p.window.remove(candidate)
+			p.probation.pushFront(candidate)
+			return victim, true
+		}
+		p.window.remove(candidate)
+		return candidate, true
+	case hasCandidate:
+		p.window.remove(candidate)
+		return candidate, true
+	case hasVictim:
+		main.remove(victim)
+		return victim, true
+	}
+	return "", false
+}
+
+func (p *tinyLFU) Reset() {
+	p.window.reset()
+	p.probation.reset()
+	p.protected.reset()
+	p.sketch.reset()
+}
+
+// countMinSketch estimates access frequencies in fixed memory with 4-bit
+// style saturating counters. Every sampleSize increments all counters are
+// halved so that old popularity fades.
+type countMinSketch struct {
+	rows       [4][]uint8
+	mask       uint64
+	seed       maphash.Seed
+	additions  int
+	sampleSize int
+}
+
+const sketchMaxCount = 15
+
+// width must be a power of two.
+func newCountMinSketch(width int) *countMinSketch {
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		seed:       maphash.MakeSeed(),
+		sampleSize: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes derives one counter per row from a single 64-bit hash.
+func (s *countMinSketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h&0xffffffff, h>>32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < sketchMaxCount {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	est := uint8(sketchMaxCount)
+	for i, j := range s.indexes(key) {
+		est = min(est, s.rows[i][j])
+	}
+	return est
+}
+
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+	s.additions = 0
+}
@@ -0,0 +1,358 @@
+// Package lock implements distributed mutexes on top of the cache.
+//
+// A lock is a key holding a random owner token, set with SET NX and a TTL.
+// Only the owner can release or extend it (compare-and-delete), so a holder
+// that outlived its TTL can't release a lock someone else has taken since.
+// While a lock is held a watchdog keeps extending its TTL, so a slow holder
+// keeps it and a crashed one blocks the others for one TTL at most.
+//
+// Given several independent Redis nodes, a lock is held once a majority of
+// them granted it within its TTL (the Redlock algorithm), so it survives
+// the loss of a minority of the nodes.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+var (
+	// ErrNotAcquired is returned when a lock is busy: right away by
+	// TryAcquire, after Config.Wait by Acquire.
+	ErrNotAcquired = errors.New("lock not acquired")
+	// ErrNotHeld is returned by Release when the lock had already expired
+	// or been released.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// nodeTimeout bounds each Redlock node call, so one slow node doesn't eat
+// the lock's validity.
+const nodeTimeout = 100 * time.Millisecond
+
+// Config tunes the locks of a Locker.
+type Config struct {
+	// TTL is how long a lock outlives a crashed holder. Held locks are
+	// extended every TTL/3.
+	TTL time.Duration
+	// Wait is how long Acquire waits for a busy lock. 0 waits until the
+	// context is done.
+	Wait time.Duration
+	// MinBackoff and MaxBackoff bound the pause between two attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultConfig returns the settings used when none are configured.
+func DefaultConfig() Config {
+	return Config{
+		TTL:        5 * time.Second,
+		Wait:       10 * time.Second,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 500 * time.Millisecond,
+	}
+}
+
+// Stats is a snapshot of the lock counters.
+type Stats struct {
+	Nodes    int   `json:"nodes"`
+	Acquired int64 `json:"acquired"`
+	// Contended counts acquisitions that had to wait for another holder.
+	Contended int64 `json:"contended"`
+	// Timeouts counts Acquire and TryAcquire calls that gave up.
+	Timeouts int64 `json:"timeouts"`
+	Renewals int64 `json:"renewals"`
+	// Lost counts locks that expired while held, because the watchdog
+	// couldn't extend them.
+	Lost int64 `json:"lost"`
+	Held int64 `json:"held"`
+}
+
+// Locker hands out locks stored on one or more cache nodes.
+type Locker struct {
+	nodes  []cache.Cache
+	quorum int
+	cfg    Config
+
+	acquired  atomic.Int64
+	contended atomic.Int64
+	timeouts  atomic.Int64
+	renewals  atomic.Int64
+	lost      atomic.Int64
+	held      atomic.Int64
+}
+
+// Default is the locker used by the repositories, set in main.
+var Default *Locker
+
+// New returns a Locker storing its locks in c.
+func New(c cache.Cache, cfg Config) *Locker {
+	return NewRedlock([]cache.Cache{c}, cfg)
+}
+
+// NewRedlock returns a Locker that holds a lock when a majority of nodes
+// granted it. The nodes must be independent masters, not replicas of each
+// other: a replica can lose a lock its master granted.
+func NewRedlock(nodes []cache.Cache, cfg Config) *Locker {
+	def := DefaultConfig()
+	if cfg.TTL <= 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = def.MinBackoff
+	}
+	cfg.MaxBackoff = max(cfg.MaxBackoff, cfg.MinBackoff)
+	return &Locker{nodes: nodes, quorum: len(nodes)/2 + 1, cfg: cfg}
+}
+
+// TryAcquire takes the lock on key, or returns ErrNotAcquired if it is
+// held.
+func (l *Locker) TryAcquire(ctx context.Context, key string) (*Lock, error) {
+	lk, err := l.try(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if lk == nil {
+		l.timeouts.Add(1)
+		return nil, ErrNotAcquired
+	}
+	return lk, nil
+}
+
+// Acquire takes the lock on key, waiting while it is held. Waiters retry
+// with a jittered exponential backoff until the lock is free, Config.Wait
+// has passed or ctx is done.
+func (l *Locker) Acquire(ctx context.Context, key string) (*Lock, error) {
+	if l.cfg.Wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.cfg.Wait)
+		defer cancel()
+	}
+
+	backoff := l.cfg.MinBackoff
+	waited := false
+	for {
+		lk, err := l.try(ctx, key)
+		if lk != nil {
+			if waited {
+				l.contended.Add(1)
+			}
+			return lk, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+
+		waited = true
+		// Full jitter, so waiters don't all retry at the same moment
+		pause := time.Duration(mrand.Int64N(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			l.timeouts.Add(1)
+			return nil, fmt.Errorf("%w: %w", ErrNotAcquired, ctx.Err())
+		case <-time.After(pause):
+		}
+		backoff = min(backoff*2, l.cfg.MaxBackoff)
+	}
+}
+
+// try makes one attempt. It returns a nil Lock if the lock is busy, and an
+// error only if too many nodes failed to tell.
+func (l *Locker) try(ctx context.Context, key string) (*Lock, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	granted, failed, err := l.onNodes(ctx, func(ctx context.Context, c cache.Cache) (bool, error) {
+		return c.SetNX(ctx, key, token, l.cfg.TTL)
+	})
+	// The lock is only valid if it was granted by a quorum before it began
+	// to expire on the first node, allowing for clock drift between nodes
+	validity := l.cfg.TTL - time.Since(start) - l.cfg.TTL/100 - 2*time.Millisecond
+	if granted >= l.quorum && validity > 0 {
+		return l.hold(key, token), nil
+	}
+
+	if granted > 0 {
+		// Undo a partial Redlock acquisition
+		l.onNodes(context.WithoutCancel(ctx), func(ctx context.Context, c cache.Cache) (bool, error) {
+			return c.DelIfEqual(ctx, key, token)
+		})
+	}
+	if len(l.nodes)-failed < l.quorum {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// onNodes runs fn on every node, concurrently when there are several, and
+// counts the nodes where it returned true and those where it failed.
+func (l *Locker) onNodes(ctx context.Context, fn func(context.Context, cache.Cache) (bool, error)) (ok, failed int, err error) {
+	if len(l.nodes) == 1 {
+		res, err := fn(ctx, l.nodes[0])
+		if err != nil {
+			return 0, 1, err
+		}
+		if res {
+			return 1, 0, nil
+		}
+		return 0, 0, nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range l.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nctx, cancel := context.WithTimeout(ctx, nodeTimeout)
+			defer cancel()
+			res, nerr := fn(nctx, node)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case nerr != nil:
+				failed++
+				if err == nil {
+					err = nerr
+				}
+			case res:
+				ok++
+			}
+		}()
+	}
+	wg.Wait()
+	return ok, failed, err
+}
+
+func (l *Locker) Stats() Stats {
+	return Stats{
+		Nodes:     len(l.nodes),
+		Acquired:  l.acquired.Load(),
+		Contended: l.contended.Load(),
+		Timeouts:  l.timeouts.Load(),
+		Renewals:  l.renewals.Load(),
+		Lost:      l.lost.Load(),
+		Held:      l.held.Load(),
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lock is a held lock. It must be released with Release.
+type Lock struct {
+	l     *Locker
+	key   string
+	token string
+
+	release sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+	lost    chan struct{}
+}
+
+func (l *Locker) hold(key, token string) *Lock {
+	lk := &Lock{
+		l:     l,
+		key:   key,
+		token: token,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}
+	l.acquired.Add(1)
+	l.held.Add(1)
+	go lk.watch()
+	return lk
+}
+
+// Lost is closed if the lock expired while held: someone else may hold it
+// now, so work that relied on it should not be committed.
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// watch extends the lock every TTL/3 until it is released. A failed
+// extension is retried until the lock would have expired; a lock found
+// expired or owned by someone else is lost.
+func (lk *Lock) watch() {
+	defer close(lk.done)
+
+	interval := lk.l.cfg.TTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		start := time.Now()
+		ok, failed, _ := lk.l.onNodes(ctx, func(ctx context.Context, c cache.Cache) (bool, error) {
+			return c.ExpireIfEqual(ctx, lk.key, lk.token, lk.l.cfg.TTL)
+		})
+		cancel()
+
+		switch {
+		case ok >= lk.l.quorum:
+			lk.l.renewals.Add(1)
+			renewed = start
+			continue
+		case len(lk.l.nodes)-failed >= lk.l.quorum:
+			// Enough nodes answered, and not as the owner
+		case time.Since(renewed) < lk.l.cfg.TTL:
+			// Nodes unreachable, the lock may still be ours
+			continue
+		}
+		lk.l.lost.Add(1)
+		close(lk.lost)
+		return
+	}
+}
+
+// Release stops the watchdog and deletes the lock if it is still ours. It
+// returns ErrNotHeld if the lock had expired.
+func (lk *Lock) Release(ctx context.Context) error {
+	first := false
+	lk.release.Do(func() {
+		first = true
+		close(lk.stop)
+	})
+	if !first {
+		return ErrNotHeld
+	}
+	<-lk.done
+	lk.l.held.Add(-1)
+
+	ok, failed, err := lk.l.onNodes(ctx, func(ctx context.Context, c cache.Cache) (bool, error) {
+		return c.DelIfEqual(ctx, lk.key, lk.token)
+	})
+	switch {
+	case ok >= lk.l.quorum:
+		return nil
+	case len(lk.l.nodes)-failed < lk.l.quorum:
+		// It expires on its own
+		return err
+	}
+	return ErrNotHeld
+}
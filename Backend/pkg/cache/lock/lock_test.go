@@ -0,0 +1,246 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wailman24/Caching.git/pkg/cache"
+)
+
+var errDown = errors.New("node down")
+
+// downCache is a cache node that can't be reached.
+type downCache struct {
+	*cache.MemoryCache
+}
+
+func (downCache) SetNX(context.Context, string, string, time.Duration) (bool, error) {
+	return false, errDown
+}
+
+func (downCache) DelIfEqual(context.Context, string, string) (bool, error) {
+	return false, errDown
+}
+
+func (downCache) ExpireIfEqual(context.Context, string, string, time.Duration) (bool, error) {
+	return false, errDown
+}
+
+// flakyCache stops extending locks once down is set.
+type flakyCache struct {
+	*cache.MemoryCache
+	down atomic.Bool
+}
+
+func (fc *flakyCache) ExpireIfEqual(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if fc.down.Load() {
+		return false, errDown
+	}
+	return fc.MemoryCache.ExpireIfEqual(ctx, key, value, ttl)
+}
+
+func testConfig(ttl time.Duration) Config {
+	return Config{TTL: ttl, Wait: 50 * time.Millisecond, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+func isLost(lk *Lock) bool {
+	select {
+	case <-lk.Lost():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestOnlyOwnerReleases(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache()
+	l := New(mc, testConfig(time.Minute))
+
+	lk, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.TryAcquire(ctx, "lock:a"); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("second TryAcquire = %v, want ErrNotAcquired", err)
+	}
+	if _, err := l.Acquire(ctx, "lock:a"); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("Acquire of a held lock = %v, want ErrNotAcquired", err)
+	}
+	// A non-owner can't delete it
+	if ok, _ := mc.DelIfEqual(ctx, "lock:a", "not-the-token"); ok {
+		t.Error("DelIfEqual by a non-owner succeeded")
+	}
+
+	if err := lk.Release(ctx); err != nil {
+		t.Fatalf("Release = %v", err)
+	}
+	if err := lk.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("second Release = %v, want ErrNotHeld", err)
+	}
+	if _, err := l.TryAcquire(ctx, "lock:a"); err != nil {
+		t.Errorf("TryAcquire after Release = %v", err)
+	}
+}
+
+func TestExpiredHolderCantReleaseNewOwner(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache()
+	l := New(mc, testConfig(time.Minute))
+
+	first, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lock expires under the first holder and someone else takes it
+	mc.Del(ctx, "lock:a")
+	second, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Release by the expired holder = %v, want ErrNotHeld", err)
+	}
+	if _, err := l.TryAcquire(ctx, "lock:a"); !errors.Is(err, ErrNotAcquired) {
+		t.Error("the expired holder released the new owner's lock")
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Errorf("Release by the new owner = %v", err)
+	}
+}
+
+func TestWatchdogRenews(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache()
+	l := New(mc, testConfig(90*time.Millisecond))
+
+	lk, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lk.Release(ctx)
+
+	// Well past the TTL, the watchdog kept it
+	time.Sleep(300 * time.Millisecond)
+	if _, err := l.TryAcquire(ctx, "lock:a"); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("TryAcquire after the TTL = %v, want ErrNotAcquired", err)
+	}
+	if isLost(lk) {
+		t.Error("lock lost while the watchdog was renewing it")
+	}
+	if st := l.Stats(); st.Renewals == 0 || st.Held != 1 {
+		t.Errorf("stats = %+v, want renewals and one held lock", st)
+	}
+}
+
+func TestLostWhenTakenOver(t *testing.T) {
+	ctx := context.Background()
+	mc := cache.NewMemoryCache()
+	l := New(mc, testConfig(60*time.Millisecond))
+
+	lk, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc.Del(ctx, "lock:a")
+	mc.SetNX(ctx, "lock:a", "someone-else", time.Minute)
+
+	select {
+	case <-lk.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost not closed after the lock was taken over")
+	}
+	if err := lk.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Release = %v, want ErrNotHeld", err)
+	}
+	if st := l.Stats(); st.Lost != 1 {
+		t.Errorf("lost = %d, want 1", st.Lost)
+	}
+}
+
+func TestLostOnExpiry(t *testing.T) {
+	ctx := context.Background()
+	node := &flakyCache{MemoryCache: cache.NewMemoryCache()}
+	ttl := 90 * time.Millisecond
+	l := New(node, testConfig(ttl))
+
+	lk, err := l.TryAcquire(ctx, "lock:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Renewals fail from now on: the lock is kept while it may still be
+	// ours, and lost once its TTL has run out
+	node.down.Store(true)
+	acquired := time.Now()
+
+	select {
+	case <-lk.Lost():
+		if since := time.Since(acquired); since < ttl {
+			t.Errorf("lost after %v, before the TTL of %v", since, ttl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Lost not closed after the lock expired")
+	}
+	if _, err := l.TryAcquire(ctx, "lock:a"); err != nil {
+		t.Errorf("TryAcquire of the expired lock = %v", err)
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		down    int
+		held    int // nodes already holding the lock for someone else
+		wantErr error
+	}{
+		{name: "all nodes up"},
+		{name: "one node down", down: 1},
+		{name: "two nodes down", down: 2, wantErr: errDown},
+		{name: "held on two nodes", held: 2, wantErr: ErrNotAcquired},
+		{name: "held on one node", held: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mcs := make([]*cache.MemoryCache, 3)
+			nodes := make([]cache.Cache, 3)
+			for i := range nodes {
+				mcs[i] = cache.NewMemoryCache()
+				nodes[i] = mcs[i]
+				if i < tt.down {
+					nodes[i] = downCache{mcs[i]}
+				}
+				if i >= 3-tt.held {
+					mcs[i].SetNX(ctx, "lock:a", "someone-else", time.Minute)
+				}
+			}
+			l := NewRedlock(nodes, testConfig(time.Minute))
+
+			lk, err := l.TryAcquire(ctx, "lock:a")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TryAcquire = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// A partial acquisition is undone
+				for i := tt.down; i < 3-tt.held; i++ {
+					if ttl, _ := mcs[i].TTL(ctx, "lock:a"); ttl != cache.KeyMissing {
+						t.Errorf("node %d still holds the lock", i)
+					}
+				}
+				return
+			}
+			if err := lk.Release(ctx); err != nil {
+				t.Errorf("Release = %v", err)
+			}
+			for i := tt.down; i < 3-tt.held; i++ {
+				if ttl, _ := mcs[i].TTL(ctx, "lock:a"); ttl != cache.KeyMissing {
+					t.Errorf("node %d still holds the lock after Release", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrWrongType = errors.New("WRONGTYPE operation against a key holding the wrong kind of value")
+
+type memKind int
+
+const (
+	memString memKind = iota
+	memHash
+	memSet
+)
+
+type memEntry struct {
+	kind     memKind
+	str      string
+	hash     map[string]string
+	set      map[string]struct{}
+	expireAt time.Time
+}
+
+func (e *memEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && now.After(e.expireAt)
+}
+
+// MemoryCache is a process-local Cache with Redis-like semantics: hashes and
+// sets are created on first write, and keys with a TTL are dropped lazily
+// once they expire.
+type MemoryCache struct {
+	mu   sync.Mutex
+	data map[string]*memEntry
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{data: make(map[string]*memEntry)}
+}
+
+// get returns a live entry for key, removing it if it has expired.
+// Callers must hold mc.mu.
+func (mc *MemoryCache) get(key string) *memEntry {
+	e, ok := mc.data[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(time.Now()) {
+		delete(mc.data, key)
+		return nil
+	}
+	return e
+}
+
+// getOrCreate returns the entry for key, creating it with the given kind.
+// Callers must hold mc.mu.
+func (mc *MemoryCache) getOrCreate(key string, kind memKind) (*memEntry, error) {
+	e := mc.get(key)
+	if e == nil {
+		e = &memEntry{kind: kind}
+		switch kind {
+		case memHash:
+			e.hash = make(map[string]string)
+		case memSet:
+			e.set = make(map[string]struct{})
+		}
+		mc.data[key] = e
+		return e, nil
+	}
+	if e.kind != kind {
+		return nil, ErrWrongType
+	}
+	return e, nil
+}
+
+func (mc *MemoryCache) HSet(ctx context.Context, key string, values map[string]string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.hset(key, values)
+}
+
+func (mc *MemoryCache) hset(key string, values map[string]string) error {
+	e, err := mc.getOrCreate(key, memHash)
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		e.hash[k] = v
+	}
+	return nil
+}
+
+func (mc *MemoryCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	res := make(map[string]string)
+	e := mc.get(key)
+	if e == nil {
+		return res, nil
+	}
+	if e.kind != memHash {
+		return nil, ErrWrongType
+	}
+	for k, v := range e.hash {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (mc *MemoryCache) HGetAllMany(ctx context.Context, keys []string) ([]map[string]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	res := make([]map[string]string, len(keys))
+	for i, key := range keys {
+		res[i] = make(map[string]string)
+		if e := mc.get(key); e != nil && e.kind == memHash {
+			for k, v := range e.hash {
+				res[i][k] = v
+			}
+		}
+	}
+	return res, nil
+}
+
+func (mc *MemoryCache) SAdd(ctx context.Context, key string, members ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.sadd(key, members)
+}
+
+func (mc *MemoryCache) sadd(key string, members []string) error {
+	e, err := mc.getOrCreate(key, memSet)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		e.set[m] = struct{}{}
+	}
+	return nil
+}
+
+func (mc *MemoryCache) SRem(ctx context.Context, key string, members ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.srem(key, members)
+}
+
+func (mc *MemoryCache) srem(key string, members []string) error {
+	e := mc.get(key)
+	if e == nil {
+		return nil
+	}
+	if e.kind != memSet {
+		return ErrWrongType
+	}
+	for _, m := range members {
+		delete(e.set, m)
+	}
+	// Redis deletes empty sets
+	if len(e.set) == 0 {
+		delete(mc.data, key)
+	}
+	return nil
+}
+
+func (mc *MemoryCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.get(key)
+	if e == nil {
+		return []string{}, nil
+	}
+	if e.kind != memSet {
+		return nil, ErrWrongType
+	}
+	res := make([]string, 0, len(e.set))
+	for m := range e.set {
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func (mc *MemoryCache) Del(ctx context.Context, keys ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.del(keys)
+	return nil
+}
+
+func (mc *MemoryCache) del(keys []string) {
+	for _, k := range keys {
+		delete(mc.data, k)
+	}
+}
+
+func (mc *MemoryCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.expire(key, ttl)
+	return nil
+}
+
+func (mc *MemoryCache) expire(key string, ttl time.Duration) {
+	e := mc.get(key)
+	if e == nil {
+		return
+	}
+	if ttl <= 0 {
+		delete(mc.data, key)
+		return
+	}
+	e.expireAt = time.Now().Add(ttl)
+}
+
+func (mc *MemoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.get(key)
+	if e == nil {
+		return KeyMissing, nil
+	}
+	if e.expireAt.IsZero() {
+		return NoExpiry, nil
+	}
+	// Redis reports whole seconds
+	return time.Until(e.expireAt).Truncate(time.Second), nil
+}
+
+func (mc *MemoryCache) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.get(key) != nil {
+		return false, nil
+	}
+	e := &memEntry{kind: memString, str: value}
+	if ttl > 0 {
+		e.expireAt = time.Now().Add(ttl)
+	}
+	mc.data[key] = e
+	return true, nil
+}
+
+func (mc *MemoryCache) DelIfEqual(ctx context.Context, key, value string) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.get(key)
+	if e == nil || e.kind != memString || e.str != value {
+		return false, nil
+	}
+	delete(mc.data, key)
+	return true, nil
+}
+
+func (mc *MemoryCache) ExpireIfEqual(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.get(key)
+	if e == nil || e.kind != memString || e.str != value {
+		return false, nil
+	}
+	e.expireAt = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (mc *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (mc *MemoryCache) Pipeline() Pipeline {
+	return &memoryPipeline{mc: mc}
+}
+
+// memoryPipeline queues commands and applies them under a single lock,
+// which gives the same all-at-once visibility as a Redis MULTI/EXEC.
+type memoryPipeline struct {
+	mc  *MemoryCache
+	ops []func() error
+}
+
+func (p *memoryPipeline) HSet(key string, values map[string]string) {
+	p.ops = append(p.ops, func() error { return p.mc.hset(key, values) })
+}
+
+func (p *memoryPipeline) SAdd(key string, members ...string) {
+	p.ops = append(p.ops, func() error { return p.mc.sadd(key, members) })
+}
+
+func (p *memoryPipeline) SRem(key string, members ...string) {
+	p.ops = append(p.ops, func() error { return p.mc.srem(key, members) })
+}
+
+func (p *memoryPipeline) Del(keys ...string) {
+	p.ops = append(p.ops, func() error { p.mc.del(keys); return nil })
+}
+
+func (p *memoryPipeline) Expire(key string, ttl time.Duration) {
+	p.ops = append(p.ops, func() error { p.mc.expire(key, ttl); return nil })
+}
+
+func (p *memoryPipeline) SAddExisting(key string, members ...string) {
+	p.ops = append(p.ops, func() error {
+		if p.mc.get(key) == nil {
+			return nil
+		}
+		return p.mc.sadd(key, members)
+	})
+}
+
+func (p *memoryPipeline) HReplaceIfNewer(key string, values map[string]string, versionField string, ttl time.Duration) {
+	p.ops = append(p.ops, func() error {
+		version, _ := strconv.ParseFloat(values[versionField], 64)
+		if e := p.mc.get(key); e != nil && e.kind == memHash {
+			if cur, err := strconv.ParseFloat(e.hash[versionField], 64); err == nil && cur > version {
+				return nil
+			}
+		}
+		p.mc.del([]string{key})
+		if err := p.mc.hset(key, values); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			p.mc.expire(key, ttl)
+		}
+		return nil
+	})
+}
+
+func (p *memoryPipeline) Exec(ctx context.Context) error {
+	p.mc.mu.Lock()
+	defer p.mc.mu.Unlock()
+
+	// Like Redis, keep going after a failed command and report the first error
+	var firstErr error
+	for _, op := range p.ops {
+		if err := op(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.ops = nil
+	return firstErr
+}
@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		wait    time.Duration
+		wantTTL time.Duration
+		exists  bool
+	}{
+		{name: "no expiry", ttl: 0, wantTTL: NoExpiry, exists: true},
+		{name: "live", ttl: time.Hour, wantTTL: 59 * time.Minute, exists: true},
+		{name: "expired", ttl: 20 * time.Millisecond, wait: 40 * time.Millisecond, wantTTL: KeyMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache()
+			mc.HSet(ctx, "k", map[string]string{"f": "v"})
+			if tt.ttl > 0 {
+				mc.Expire(ctx, "k", tt.ttl)
+			}
+			time.Sleep(tt.wait)
+
+			ttl, err := mc.TTL(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Whole seconds, like Redis
+			if ttl != tt.wantTTL && !(tt.wantTTL > 0 && ttl > tt.wantTTL) {
+				t.Errorf("TTL = %v, want %v", ttl, tt.wantTTL)
+			}
+			h, _ := mc.HGetAll(ctx, "k")
+			if got := len(h) > 0; got != tt.exists {
+				t.Errorf("exists = %v, want %v", got, tt.exists)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheLazyExpiry(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+	mc.SAdd(ctx, "s", "a")
+	mc.Expire(ctx, "s", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	// Nothing sweeps expired keys, the next access drops them
+	if _, ok := mc.data["s"]; !ok {
+		t.Fatal("expired key dropped before being accessed")
+	}
+	members, _ := mc.SMembers(ctx, "s")
+	if len(members) != 0 {
+		t.Errorf("SMembers = %v, want none", members)
+	}
+	if _, ok := mc.data["s"]; ok {
+		t.Error("expired key kept after access")
+	}
+
+	// An expired key can be recreated with another type
+	if err := mc.HSet(ctx, "s", map[string]string{"f": "v"}); err != nil {
+		t.Errorf("HSet on expired set: %v", err)
+	}
+}
+
+func TestMemoryCacheExpireZeroDeletes(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+	mc.HSet(ctx, "k", map[string]string{"f": "v"})
+	mc.Expire(ctx, "k", 0)
+	if ttl, _ := mc.TTL(ctx, "k"); ttl != KeyMissing {
+		t.Errorf("TTL = %v, want %v", ttl, KeyMissing)
+	}
+}
+
+func TestMemoryCacheWrongType(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		setup func(mc *MemoryCache)
+		op    func(mc *MemoryCache) error
+	}{
+		{
+			name:  "HSet on a set",
+			setup: func(mc *MemoryCache) { mc.SAdd(ctx, "k", "a") },
+			op:    func(mc *MemoryCache) error { return mc.HSet(ctx, "k", map[string]string{"f": "v"}) },
+		},
+		{
+			name:  "HGetAll on a set",
+			setup: func(mc *MemoryCache) { mc.SAdd(ctx, "k", "a") },
+			op:    func(mc *MemoryCache) error { _, err := mc.HGetAll(ctx, "k"); return err },
+		},
+		{
+			name:  "SAdd on a hash",
+			setup: func(mc *MemoryCache) { mc.HSet(ctx, "k", map[string]string{"f": "v"}) },
+			op:    func(mc *MemoryCache) error { return mc.SAdd(ctx, "k", "a") },
+		},
+		{
+			name:  "SRem on a hash",
+			setup: func(mc *MemoryCache) { mc.HSet(ctx, "k", map[string]string{"f": "v"}) },
+			op:    func(mc *MemoryCache) error { return mc.SRem(ctx, "k", "a") },
+		},
+		{
+			name:  "SMembers on a lock",
+			setup: func(mc *MemoryCache) { mc.SetNX(ctx, "k", "token", time.Minute) },
+			op:    func(mc *MemoryCache) error { _, err := mc.SMembers(ctx, "k"); return err },
+		},
+		{
+			name:  "pipelined SAdd on a hash",
+			setup: func(mc *MemoryCache) { mc.HSet(ctx, "k", map[string]string{"f": "v"}) },
+			op: func(mc *MemoryCache) error {
+				pipe := mc.Pipeline()
+				pipe.SAdd("k", "a")
+				pipe.HSet("other", map[string]string{"f": "v"})
+				return pipe.Exec(ctx)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache()
+			tt.setup(mc)
+			if err := tt.op(mc); !errors.Is(err, ErrWrongType) {
+				t.Errorf("err = %v, want ErrWrongType", err)
+			}
+		})
+	}
+}
+
+func TestMemoryCachePipelineKeepsGoingAfterError(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+	mc.HSet(ctx, "k", map[string]string{"f": "v"})
+
+	pipe := mc.Pipeline()
+	pipe.SAdd("k", "a")
+	pipe.HSet("other", map[string]string{"f": "v"})
+	if err := pipe.Exec(ctx); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("Exec = %v, want ErrWrongType", err)
+	}
+	if h, _ := mc.HGetAll(ctx, "other"); h["f"] != "v" {
+		t.Error("command after the failed one was not applied")
+	}
+}
+
+func TestMemoryCacheHGetAllMany(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+	mc.HSet(ctx, "a", map[string]string{"f": "1"})
+	mc.SAdd(ctx, "set", "x")
+
+	res, err := mc.HGetAllMany(ctx, []string{"a", "missing", "set"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || res[0]["f"] != "1" || len(res[1]) != 0 || len(res[2]) != 0 {
+		t.Errorf("HGetAllMany = %v", res)
+	}
+}
+
+func TestMemoryCacheEmptySetDeleted(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		members []string
+		remove  []string
+		want    []string
+		exists  bool
+	}{
+		{name: "some left", members: []string{"a", "b"}, remove: []string{"a"}, want: []string{"b"}, exists: true},
+		{name: "last removed", members: []string{"a"}, remove: []string{"a"}, want: []string{}},
+		{name: "all removed at once", members: []string{"a", "b"}, remove: []string{"a", "b", "c"}, want: []string{}},
+		{name: "missing key", remove: []string{"a"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache()
+			if len(tt.members) > 0 {
+				mc.SAdd(ctx, "s", tt.members...)
+			}
+			if err := mc.SRem(ctx, "s", tt.remove...); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := mc.SMembers(ctx, "s")
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SMembers = %v, want %v", got, tt.want)
+			}
+			if ttl, _ := mc.TTL(ctx, "s"); (ttl != KeyMissing) != tt.exists {
+				t.Errorf("key exists = %v, want %v", ttl != KeyMissing, tt.exists)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheSAddExisting(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		initial []string
+		add     []string
+		want    []string
+	}{
+		{name: "existing set", initial: []string{"1"}, add: []string{"2", "3"}, want: []string{"1", "2", "3"}},
+		// A lone write must not create an index holding one member
+		{name: "missing set", add: []string{"2"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache()
+			if len(tt.initial) > 0 {
+				mc.SAdd(ctx, "idx", tt.initial...)
+			}
+			pipe := mc.Pipeline()
+			pipe.SAddExisting("idx", tt.add...)
+			if err := pipe.Exec(ctx); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := mc.SMembers(ctx, "idx")
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SMembers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheHReplaceIfNewer(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		current map[string]string
+		values  map[string]string
+		want    map[string]string
+	}{
+		{
+			name:   "missing key",
+			values: map[string]string{"name": "new", "version": "2"},
+			want:   map[string]string{"name": "new", "version": "2"},
+		},
+		{
+			name:    "newer version replaces every field",
+			current: map[string]string{"name": "old", "stale": "x", "version": "1"},
+			values:  map[string]string{"name": "new", "version": "2"},
+			want:    map[string]string{"name": "new", "version": "2"},
+		},
+		{
+			name:    "same version replaces",
+			current: map[string]string{"name": "old", "version": "2"},
+			values:  map[string]string{"name": "new", "version": "2"},
+			want:    map[string]string{"name": "new", "version": "2"},
+		},
+		{
+			// An older refill must not overwrite a newer write
+			name:    "older version is ignored",
+			current: map[string]string{"name": "newer", "version": "3"},
+			values:  map[string]string{"name": "older", "version": "2"},
+			want:    map[string]string{"name": "newer", "version": "3"},
+		},
+		{
+			name:    "unversioned hash is replaced",
+			current: map[string]string{"name": "old"},
+			values:  map[string]string{"name": "new", "version": "1"},
+			want:    map[string]string{"name": "new", "version": "1"},
+		},
+		{
+			name:    "tombstone is replaced",
+			current: map[string]string{"tombstone": "1"},
+			values:  map[string]string{"name": "new", "version": "1"},
+			want:    map[string]string{"name": "new", "version": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache()
+			if tt.current != nil {
+				mc.HSet(ctx, "k", tt.current)
+			}
+			pipe := mc.Pipeline()
+			pipe.HReplaceIfNewer("k", tt.values, "version", time.Hour)
+			if err := pipe.Exec(ctx); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := mc.HGetAll(ctx, "k")
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("hash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheHReplaceIfNewerTTL(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+	pipe := mc.Pipeline()
+	pipe.HReplaceIfNewer("k", map[string]string{"version": "1"}, "version", time.Hour)
+	pipe.HReplaceIfNewer("persistent", map[string]string{"version": "1"}, "version", 0)
+	pipe.Exec(ctx)
+
+	if ttl, _ := mc.TTL(ctx, "k"); ttl <= 0 {
+		t.Errorf("TTL = %v, want an expiry", ttl)
+	}
+	if ttl, _ := mc.TTL(ctx, "persistent"); ttl != NoExpiry {
+		t.Errorf("TTL = %v, want %v", ttl, NoExpiry)
+	}
+}
+
+func TestMemoryCacheOwnerOps(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache()
+
+	if ok, _ := mc.SetNX(ctx, "lock", "owner", time.Minute); !ok {
+		t.Fatal("SetNX on a free key failed")
+	}
+	if ok, _ := mc.SetNX(ctx, "lock", "other", time.Minute); ok {
+		t.Error("SetNX on a held key succeeded")
+	}
+	if ok, _ := mc.ExpireIfEqual(ctx, "lock", "other", time.Hour); ok {
+		t.Error("ExpireIfEqual by a non-owner succeeded")
+	}
+	if ok, _ := mc.DelIfEqual(ctx, "lock", "other"); ok {
+		t.Error("DelIfEqual by a non-owner succeeded")
+	}
+	if ok, _ := mc.ExpireIfEqual(ctx, "lock", "owner", time.Hour); !ok {
+		t.Error("ExpireIfEqual by the owner failed")
+	}
+	if ok, _ := mc.DelIfEqual(ctx, "lock", "owner"); !ok {
+		t.Error("DelIfEqual by the owner failed")
+	}
+	if ok, _ := mc.SetNX(ctx, "lock", "other", time.Minute); !ok {
+		t.Error("SetNX after release failed")
+	}
+}
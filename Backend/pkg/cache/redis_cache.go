@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache implements Cache on top of a go-redis client.
+type RedisCache struct {
+	rdb *redis.Client
+}
+
+func NewRedisCache(rdb *redis.Client) *RedisCache {
+	return &RedisCache{rdb: rdb}
+}
+
+// Client exposes the underlying client for Redis-only features.
+func (rc *RedisCache) Client() *redis.Client {
+	return rc.rdb
+}
+
+func (rc *RedisCache) HSet(ctx context.Context, key string, values map[string]string) error {
+	return rc.rdb.HSet(ctx, key, values).Err()
+}
+
+func (rc *RedisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return rc.rdb.HGetAll(ctx, key).Result()
+}
+
+func (rc *RedisCache) HGetAllMany(ctx context.Context, keys []string) ([]map[string]string, error) {
+	pipe := rc.rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, key)
+	}
+	// Per-command errors (WRONGTYPE) are read below, only a failed round
+	// trip fails the whole call
+	if _, err := pipe.Exec(ctx); err != nil && !isCommandError(cmds) {
+		return nil, err
+	}
+
+	res := make([]map[string]string, len(keys))
+	for i, cmd := range cmds {
+		res[i] = cmd.Val()
+		if res[i] == nil || cmd.Err() != nil {
+			res[i] = make(map[string]string)
+		}
+	}
+	return res, nil
+}
+
+// isCommandError reports whether a pipeline got its replies, some of them
+// errors, as opposed to failing as a whole.
+func isCommandError(cmds []*redis.MapStringStringCmd) bool {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			var rerr redis.Error
+			if !errors.As(err, &rerr) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rc *RedisCache) SAdd(ctx context.Context, key string, members ...string) error {
+	return rc.rdb.SAdd(ctx, key, toArgs(members)...).Err()
+}
+
+func (rc *RedisCache) SRem(ctx context.Context, key string, members ...string) error {
+	return rc.rdb.SRem(ctx, key, toArgs(members)...).Err()
+}
+
+func (rc *RedisCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return rc.rdb.SMembers(ctx, key).Result()
+}
+
+func (rc *RedisCache) Del(ctx context.Context, keys ...string) error {
+	return rc.rdb.Del(ctx, keys...).Err()
+}
+
+func (rc *RedisCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return rc.rdb.Expire(ctx, key, ttl).Err()
+}
+
+func (rc *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return rc.rdb.TTL(ctx, key).Result()
+}
+
+func (rc *RedisCache) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return rc.rdb.SetNX(ctx, key, value, ttl).Result()
+}
+
+// Compare-and-delete and compare-and-expire have to be atomic: between a
+// GET and a DEL the key may expire and be taken by someone else.
+var delIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+var expireIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+func (rc *RedisCache) DelIfEqual(ctx context.Context, key, value string) (bool, error) {
+	n, err := delIfEqualScript.Run(ctx, rc.rdb, []string{key}, value).Int()
+	return n == 1, err
+}
+
+func (rc *RedisCache) ExpireIfEqual(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	n, err := expireIfEqualScript.Run(ctx, rc.rdb, []string{key}, value, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (rc *RedisCache) Ping(ctx context.Context) error {
+	return rc.rdb.Ping(ctx).Err()
+}
+
+func (rc *RedisCache) Pipeline() Pipeline {
+	return &redisPipeline{pipe: rc.rdb.Pipeline()}
+}
+
+type redisPipeline struct {
+	pipe redis.Pipeliner
+}
+
+func (p *redisPipeline) HSet(key string, values map[string]string) {
+	p.pipe.HSet(context.Background(), key, values)
+}
+
+func (p *redisPipeline) SAdd(key string, members ...string) {
+	p.pipe.SAdd(context.Background(), key, toArgs(members)...)
+}
+
+func (p *redisPipeline) SRem(key string, members ...string) {
+	p.pipe.SRem(context.Background(), key, toArgs(members)...)
+}
+
+func (p *redisPipeline) Del(keys ...string) {
+	p.pipe.Del(context.Background(), keys...)
+}
+
+func (p *redisPipeline) Expire(key string, ttl time.Duration) {
+	p.pipe.Expire(context.Background(), key, ttl)
+}
+
+var saddExistingScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("SADD", KEYS[1], unpack(ARGV))
+end
+return 0`)
+
+func (p *redisPipeline) SAddExisting(key string, members ...string) {
+	saddExistingScript.Eval(context.Background(), p.pipe, []string{key}, toArgs(members)...)
+}
+
+var hreplaceIfNewerScript = redis.NewScript(`
+local cur = tonumber(redis.call("HGET", KEYS[1], ARGV[1]))
+if cur and cur > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+redis.call("HSET", KEYS[1], unpack(ARGV, 4))
+if tonumber(ARGV[3]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return 1`)
+
+func (p *redisPipeline) HReplaceIfNewer(key string, values map[string]string, versionField string, ttl time.Duration) {
+	version := values[versionField]
+	if version == "" {
+		version = "0"
+	}
+	args := []any{versionField, version, ttl.Milliseconds()}
+	for field, value := range values {
+		args = append(args, field, value)
+	}
+	hreplaceIfNewerScript.Eval(context.Background(), p.pipe, []string{key}, args...)
+}
+
+func (p *redisPipeline) Exec(ctx context.Context) error {
+	if p.pipe.Len() == 0 {
+		return nil
+	}
+	_, err := p.pipe.Exec(ctx)
+	return err
+}
+
+func toArgs(members []string) []interface{} {
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return args
+}
@@ -4,26 +4,54 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var Rdb *redis.Client
 
+// connectErr is why Redis didn't answer at startup, see GuardStore.
+var connectErr error
+
+// ConnectRedis creates the client. A server that doesn't answer yet is
+// only logged: the client reconnects by itself, and the repositories go
+// to the database meanwhile.
 func ConnectRedis() {
 	ctx := context.Background()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "redis:6379"
+	}
 	// 1. Initialize the Redis client
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     "redis:6379",
+		Addr:     addr,
 		Password: "",
 		DB:       0,
 	})
+	Rdb = rdb
 	pong, err := rdb.Ping(ctx).Result()
 	if err != nil {
-		log.Fatalf("Could not connect to Redis: %v", err)
+		connectErr = err
+		log.Printf("Could not connect to Redis, starting without it: %v", err)
+		return
 	}
 	fmt.Println("Connected to Redis:", pong)
+}
 
-	Rdb = rdb
-
+// DialNodes returns a Cache for each Redis address, e.g. the independent
+// nodes of a Redlock. Nodes that don't answer are only logged: a Redlock
+// keeps working while a majority of its nodes is up.
+func DialNodes(addrs []string) []Cache {
+	nodes := make([]Cache, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		rdb := redis.NewClient(&redis.Options{Addr: addr})
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			log.Printf("Redis node %s unreachable: %v", addr, err)
+		}
+		nodes = append(nodes, NewRedisCache(rdb))
+	}
+	return nodes
 }
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A minimal RESP3 codec for the client tracking connection. go-redis hides
+// out-of-band push messages from the caller, so that connection talks to
+// Redis directly.
+
+// respPush is an out-of-band push message (">"), e.g. an invalidation.
+type respPush []any
+
+// respError is an error reply ("-" or "!").
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+// RedisError makes it a redis.Error: a reply from a working server, which
+// the breaker doesn't count as a failure.
+func (e respError) RedisError() {}
+
+func writeCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// readReply reads one RESP3 value. Strings of every kind are returned as
+// string, numbers as int64, null as nil, aggregates as []any (maps are
+// flattened to key, value pairs) and pushes as respPush. Attributes are
+// skipped.
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("resp: malformed line %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+', ',', '(':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '#':
+		if body == "t" {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case '_':
+		return nil, nil
+	case '$', '=', '!':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		s := string(buf[:n])
+		if kind == '!' {
+			return respError(s), nil
+		}
+		return s, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if kind == '%' || kind == '|' {
+			n *= 2
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		switch kind {
+		case '>':
+			return respPush(items), nil
+		case '|':
+			// attributes annotate the reply that follows
+			return readReply(rd)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("resp: unknown type %q", kind)
+}
@@ -0,0 +1,90 @@
+// Package singleflight coalesces concurrent loads of the same key: the first
+// caller runs the loader and everyone who asks for that key meanwhile waits
+// for its result instead of running their own.
+package singleflight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type call[T any] struct {
+	done    chan struct{}
+	val     T
+	err     error
+	waiters int
+}
+
+// Group runs at most one loader per key at a time. The zero value is ready
+// to use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+
+	loads     atomic.Int64
+	coalesced atomic.Int64
+	inFlight  atomic.Int64
+}
+
+// Stats is a snapshot of the group counters.
+type Stats struct {
+	// Loads is how many times a loader actually ran.
+	Loads int64 `json:"loads"`
+	// Coalesced is how many callers reused another caller's load instead
+	// of running their own.
+	Coalesced int64 `json:"coalesced"`
+	// InFlight is the number of loaders running right now.
+	InFlight int64 `json:"in_flight"`
+}
+
+// Do runs fn for key unless a call for key is already running, in which case
+// it waits for that call and returns its result. shared reports whether the
+// result came from another caller's load.
+//
+// A waiter whose ctx is cancelled returns ctx.Err() without affecting the
+// running load. The loader itself should not depend on the first caller's
+// cancellation; use context.WithoutCancel for the work done inside fn.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func() (T, error)) (v T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		g.coalesced.Add(1)
+
+		select {
+		case <-c.done:
+			return c.val, c.err, true
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err(), true
+		}
+	}
+	c := &call[T]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.loads.Add(1)
+	g.inFlight.Add(1)
+	c.val, c.err = fn()
+	g.inFlight.Add(-1)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	shared = c.waiters > 0
+	g.mu.Unlock()
+	close(c.done)
+
+	return c.val, c.err, shared
+}
+
+func (g *Group[T]) Stats() Stats {
+	return Stats{
+		Loads:     g.loads.Load(),
+		Coalesced: g.coalesced.Load(),
+		InFlight:  g.inFlight.Load(),
+	}
+}
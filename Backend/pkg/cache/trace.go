@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Tier identifies where a read was served from.
+type Tier string
+
+const (
+	TierL1 Tier = "l1" // in-process cache
+	TierL2 Tier = "l2" // shared cache (Redis)
+	TierDB Tier = "db" // cache miss, loaded from the database
+)
+
+var traceTiers = []Tier{TierL1, TierL2, TierDB}
+
+// Trace counts the tiers that served reads during a single request.
+type Trace struct {
+	mu     sync.Mutex
+	counts map[Tier]int
+	// reads that waited on another request's load instead of running one
+	coalesced int
+	// reads answered with an expired entry because reloading it failed
+	stale int
+}
+
+type traceKey struct{}
+
+// Op names the operation reads belong to, so they can be counted per
+// operation and strategy.
+type Op struct {
+	Entity    string
+	Operation string
+	Strategy  string
+}
+
+type opKey struct{}
+
+// WithOp tags the reads later recorded through ctx with op.
+func WithOp(ctx context.Context, op Op) context.Context {
+	return context.WithValue(ctx, opKey{}, op)
+}
+
+// ReadObserver is told about every read recorded through a context tagged
+// with WithOp, whether or not it carries a Trace.
+type ReadObserver interface {
+	ObserveTier(op Op, tier Tier)
+	ObserveStale(op Op)
+	ObserveCoalesced(op Op)
+}
+
+// Observer receives the tagged reads, e.g. to export them as metrics. It
+// is set in main, nil disables it.
+var Observer ReadObserver
+
+func observe(ctx context.Context, fn func(ReadObserver, Op)) {
+	if Observer == nil {
+		return
+	}
+	if op, ok := ctx.Value(opKey{}).(Op); ok {
+		fn(Observer, op)
+	}
+}
+
+// Counter totals the reads recorded through contexts carrying it over the
+// lifetime of its owner (e.g. a repository), where a Trace only covers one
+// request.
+type Counter struct {
+	l1, l2, db       atomic.Int64
+	stale, coalesced atomic.Int64
+}
+
+// ReadCounts is a snapshot of a Counter. Hits are the reads served by L1
+// or L2, misses the ones that went to the database.
+type ReadCounts struct {
+	L1        int64   `json:"l1"`
+	L2        int64   `json:"l2"`
+	DB        int64   `json:"db"`
+	Stale     int64   `json:"stale"`
+	Coalesced int64   `json:"coalesced"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+type counterKey struct{}
+
+// WithCounter adds the reads later recorded through ctx to c.
+func WithCounter(ctx context.Context, c *Counter) context.Context {
+	return context.WithValue(ctx, counterKey{}, c)
+}
+
+func (c *Counter) Counts() ReadCounts {
+	rc := ReadCounts{
+		L1:        c.l1.Load(),
+		L2:        c.l2.Load(),
+		DB:        c.db.Load(),
+		Stale:     c.stale.Load(),
+		Coalesced: c.coalesced.Load(),
+	}
+	rc.Hits = rc.L1 + rc.L2
+	rc.Misses = rc.DB
+	if total := rc.Hits + rc.Misses; total > 0 {
+		rc.HitRate = float64(rc.Hits) / float64(total)
+	}
+	return rc
+}
+
+// Add sums the counts of several counters.
+func (rc ReadCounts) Add(o ReadCounts) ReadCounts {
+	sum := ReadCounts{
+		L1:        rc.L1 + o.L1,
+		L2:        rc.L2 + o.L2,
+		DB:        rc.DB + o.DB,
+		Stale:     rc.Stale + o.Stale,
+		Coalesced: rc.Coalesced + o.Coalesced,
+		Hits:      rc.Hits + o.Hits,
+		Misses:    rc.Misses + o.Misses,
+	}
+	if total := sum.Hits + sum.Misses; total > 0 {
+		sum.HitRate = float64(sum.Hits) / float64(total)
+	}
+	return sum
+}
+
+func counter(ctx context.Context) *Counter {
+	c, _ := ctx.Value(counterKey{}).(*Counter)
+	return c
+}
+
+// WithTrace attaches a new Trace to ctx.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{counts: make(map[Tier]int)}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// RecordTier notes that a read was served by tier. The Trace part is a
+// no-op when ctx carries none.
+func RecordTier(ctx context.Context, tier Tier) {
+	observe(ctx, func(o ReadObserver, op Op) { o.ObserveTier(op, tier) })
+	if c := counter(ctx); c != nil {
+		switch tier {
+		case TierL1:
+			c.l1.Add(1)
+		case TierL2:
+			c.l2.Add(1)
+		case TierDB:
+			c.db.Add(1)
+		}
+	}
+	t, ok := ctx.Value(traceKey{}).(*Trace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.counts[tier]++
+	t.mu.Unlock()
+}
+
+// RecordCoalesced notes that a read shared another caller's load.
+func RecordCoalesced(ctx context.Context) {
+	observe(ctx, func(o ReadObserver, op Op) { o.ObserveCoalesced(op) })
+	if c := counter(ctx); c != nil {
+		c.coalesced.Add(1)
+	}
+	t, ok := ctx.Value(traceKey{}).(*Trace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.coalesced++
+	t.mu.Unlock()
+}
+
+// RecordStale notes that a read was served a stale entry.
+func RecordStale(ctx context.Context) {
+	observe(ctx, func(o ReadObserver, op Op) { o.ObserveStale(op) })
+	if c := counter(ctx); c != nil {
+		c.stale.Add(1)
+	}
+	t, ok := ctx.Value(traceKey{}).(*Trace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.stale++
+	t.mu.Unlock()
+}
+
+func (t *Trace) Empty() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.counts) == 0
+}
+
+// String formats the counts as "l1=3, l2=1, db=0", followed by
+// ", coalesced=N" and ", stale=N" when some reads were coalesced or stale.
+func (t *Trace) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(traceTiers))
+	for _, tier := range traceTiers {
+		parts = append(parts, fmt.Sprintf("%s=%d", tier, t.counts[tier]))
+	}
+	if t.coalesced > 0 {
+		parts = append(parts, fmt.Sprintf("coalesced=%d", t.coalesced))
+	}
+	if t.stale > 0 {
+		parts = append(parts, fmt.Sprintf("stale=%d", t.stale))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wailman24/Caching.git/pkg/cache/invalidation"
+)
+
+// Tracking is the client tracking connection opened by ConnectTracking, nil
+// when the server doesn't support it.
+var Tracking *Tracker
+
+var ErrTrackingUnsupported = errors.New("redis server does not support client tracking")
+
+// trackingPingInterval is how long the tracking connection may stay silent
+// before it is pinged, and then how long the ping may take.
+const trackingPingInterval = 30 * time.Second
+
+// TrackingStats is a snapshot of the tracker counters.
+type TrackingStats struct {
+	Prefixes      []string `json:"prefixes"`
+	Connected     bool     `json:"connected"`
+	Invalidations int64    `json:"invalidations"`
+	// Flushes counts FLUSHALL notifications and reconnects, after which
+	// every local entry is dropped.
+	Flushes    int64 `json:"flushes"`
+	Reconnects int64 `json:"reconnects"`
+}
+
+// Tracker uses Redis server-assisted client-side caching (Redis 6+): Redis
+// itself sends an invalidation for every key under the tracked prefixes as
+// soon as it changes, whoever changed it.
+//
+// It runs in broadcast mode (CLIENT TRACKING ON BCAST) on one dedicated
+// RESP3 connection. The default mode only tracks keys read on the same
+// connection, which doesn't work with the go-redis pool serving the reads.
+// For the same reason NOLOOP can't hide this instance's own writes, which
+// go through the pool: they are reported too, and evict the local entry the
+// writer has just set.
+type Tracker struct {
+	opt      *redis.Options
+	prefixes []string
+	breaker  *Breaker
+
+	mu          sync.RWMutex
+	subscribers []invalidation.Subscriber
+	conn        net.Conn
+
+	invalidations atomic.Int64
+	flushes       atomic.Int64
+	reconnects    atomic.Int64
+	connected     atomic.Bool
+}
+
+func NewTracker(opt *redis.Options, prefixes ...string) *Tracker {
+	return &Tracker{opt: opt, prefixes: prefixes}
+}
+
+// ConnectTracking starts tracking the given key prefixes on the server Rdb
+// is connected to and sets Tracking. It returns ErrTrackingUnsupported if
+// the server is older than Redis 6.
+func ConnectTracking(ctx context.Context, prefixes ...string) error {
+	if Rdb == nil {
+		return errors.New("client tracking needs the Redis cache backend")
+	}
+	t := NewTracker(Rdb.Options(), prefixes...)
+	t.Guard(StoreBreaker)
+	if err := t.Start(ctx); err != nil {
+		return err
+	}
+	Tracking = t
+	return nil
+}
+
+// Guard routes the connection attempts through b: none is made while it is
+// open, and their failures, as well as a lost connection, count towards
+// opening it.
+func (t *Tracker) Guard(b *Breaker) {
+	t.breaker = b
+}
+
+// Subscribe registers s for every invalidation received from now on.
+func (t *Tracker) Subscribe(s invalidation.Subscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = append(t.subscribers, s)
+}
+
+// Start opens the tracking connection and handles invalidations in the
+// background until ctx is done. Nothing is started if the first connection
+// fails.
+func (t *Tracker) Start(ctx context.Context) error {
+	conn, rd, err := t.guardedConnect(ctx)
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.conn.Close()
+	})
+	go t.run(ctx, conn, rd)
+	return nil
+}
+
+// guardedConnect connects through the breaker.
+func (t *Tracker) guardedConnect(ctx context.Context) (conn net.Conn, rd *bufio.Reader, err error) {
+	err = t.breaker.Do(func() (err error) {
+		conn, rd, err = t.connect(ctx)
+		return err
+	})
+	return conn, rd, err
+}
+
+// connect opens a RESP3 connection and enables broadcast tracking on it.
+func (t *Tracker) connect(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	hello := []string{"HELLO", "3"}
+	if t.opt.Password != "" {
+		user := t.opt.Username
+		if user == "" {
+			user = "default"
+		}
+		hello = append(hello, "AUTH", user, t.opt.Password)
+	}
+	tracking := []string{"CLIENT", "TRACKING", "ON", "BCAST"}
+	for _, p := range t.prefixes {
+		tracking = append(tracking, "PREFIX", p)
+	}
+
+	for _, cmd := range [][]string{hello, tracking} {
+		if err := writeCommand(w, cmd...); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		reply, err := readReply(rd)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if e, ok := reply.(respError); ok {
+			conn.Close()
+			// Redis < 6 answers "unknown command" to both
+			return nil, nil, fmt.Errorf("%w: %s: %w", ErrTrackingUnsupported, cmd[0], e)
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+	t.connected.Store(true)
+	return conn, rd, nil
+}
+
+// dial opens the connection the way the go-redis pool does: through the
+// client's dialer, which handles TLS, or over TLS when the options have a
+// TLSConfig but no dialer yet.
+func (t *Tracker) dial(ctx context.Context) (net.Conn, error) {
+	network := t.opt.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if t.opt.Dialer != nil {
+		return t.opt.Dialer(ctx, network, t.opt.Addr)
+	}
+	return redis.NewDialer(t.opt)(ctx, network, t.opt.Addr)
+}
+
+func (t *Tracker) run(ctx context.Context, conn net.Conn, rd *bufio.Reader) {
+	w := bufio.NewWriter(conn)
+	pinged := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(trackingPingInterval))
+		reply, err := readReply(rd)
+
+		var netErr net.Error
+		if err != nil && errors.As(err, &netErr) && netErr.Timeout() && !pinged && ctx.Err() == nil {
+			// Quiet connection: check it is still alive
+			pinged = true
+			if err = writeCommand(w, "PING"); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			conn.Close()
+			t.connected.Store(false)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("Client tracking connection lost:", err)
+			t.breaker.Record(err)
+
+			if conn, rd = t.reconnect(ctx); conn == nil {
+				return
+			}
+			w = bufio.NewWriter(conn)
+			pinged = false
+			// Keys changed while disconnected were never reported
+			t.reconnects.Add(1)
+			fmt.Println("Client tracking reconnected, flushing local cache")
+			t.flush()
+			continue
+		}
+
+		pinged = false
+		push, ok := reply.(respPush)
+		if !ok || len(push) < 2 || push[0] != "invalidate" {
+			// PONG, or a push we don't care about
+			continue
+		}
+		switch keys := push[1].(type) {
+		case nil:
+			// FLUSHALL / FLUSHDB
+			t.flush()
+		case []any:
+			for _, k := range keys {
+				if key, ok := k.(string); ok {
+					t.invalidate(key)
+				}
+			}
+		}
+	}
+}
+
+// reconnect retries with backoff until it succeeds or ctx is done.
+func (t *Tracker) reconnect(ctx context.Context) (net.Conn, *bufio.Reader) {
+	backoff := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(backoff):
+		}
+		conn, rd, err := t.guardedConnect(ctx)
+		if err == nil {
+			if ctx.Err() != nil {
+				conn.Close()
+				return nil, nil
+			}
+			return conn, rd
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (t *Tracker) invalidate(key string) {
+	t.invalidations.Add(1)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, s := range t.subscribers {
+		if s.Invalidate != nil {
+			s.Invalidate(key)
+		}
+	}
+}
+
+func (t *Tracker) flush() {
+	t.flushes.Add(1)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, s := range t.subscribers {
+		if s.Flush != nil {
+			s.Flush()
+		}
+	}
+}
+
+func (t *Tracker) Stats() TrackingStats {
+	return TrackingStats{
+		Prefixes:      t.prefixes,
+		Connected:     t.connected.Load(),
+		Invalidations: t.invalidations.Load(),
+		Flushes:       t.flushes.Load(),
+		Reconnects:    t.reconnects.Load(),
+	}
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tlsRedis accepts TLS connections and answers +OK to every command, enough
+// for the tracker's handshake. It returns its address and the client TLS
+// config trusting it.
+func tlsRedis(t *testing.T) (string, *tls.Config) {
+	t.Helper()
+	// httptest has a certificate for 127.0.0.1 at hand
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	serverTLS, cert := srv.TLS.Clone(), srv.Certificate()
+	srv.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+				for {
+					if _, err := readReply(rd); err != nil {
+						return
+					}
+					w.WriteString("+OK\r\n")
+					w.Flush()
+				}
+			}(conn)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return ln.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func TestTrackerConnectsOverTLS(t *testing.T) {
+	addr, clientTLS := tlsRedis(t)
+	opts := map[string]*redis.Options{
+		"raw options":    {Addr: addr, TLSConfig: clientTLS},
+		"client options": redis.NewClient(&redis.Options{Addr: addr, TLSConfig: clientTLS}).Options(),
+	}
+	for name, opt := range opts {
+		t.Run(name, func(t *testing.T) {
+			conn, _, err := NewTracker(opt, "product:").connect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, ok := conn.(*tls.Conn); !ok {
+				t.Errorf("connected with %T, want a TLS connection", conn)
+			}
+		})
+	}
+}
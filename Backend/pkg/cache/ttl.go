@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// TTLPolicy is the expiry applied to one family of keys. Each write gets
+// Base plus a random amount up to Jitter, so keys written together (a cold
+// GetAllProducts refill, a warm-up) don't all expire in the same second and
+// stampede the database at once.
+type TTLPolicy struct {
+	Base   time.Duration
+	Jitter time.Duration
+
+	// RefreshAhead enables refresh-ahead: an entry read when it has less
+	// than this left to live is served as is and reloaded in the
+	// background, so hot keys never block on a miss. Zero disables it.
+	RefreshAhead time.Duration
+	// StaleIfError keeps entries this long past their TTL so they can still
+	// be served when reloading them fails (the database is down). Zero
+	// disables it.
+	StaleIfError time.Duration
+}
+
+// Next returns the TTL for a single write. Zero means no expiry.
+func (p TTLPolicy) Next() time.Duration {
+	if p.Base <= 0 {
+		return 0
+	}
+	if p.Jitter <= 0 {
+		return p.Base
+	}
+	return p.Base + rand.N(p.Jitter)
+}
+
+// KeepFor is how long the cache should actually hold an entry whose TTL is
+// ttl: the TTL plus the stale-if-error grace period.
+func (p TTLPolicy) KeepFor(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return 0
+	}
+	return ttl + p.StaleIfError
+}
+
+// KeyTTL reports the remaining lifetime of a key for debugging.
+type KeyTTL struct {
+	Key string `json:"key"`
+	// TTLSeconds is -1 for a key without expiry and -2 for a missing key,
+	// as returned by Redis.
+	TTLSeconds int64 `json:"ttl_seconds"`
+	// configured policy, e.g. "1h0m0s" and "5m0s"
+	Base   string `json:"base"`
+	Jitter string `json:"jitter"`
+}
+
+// NewKeyTTL builds a KeyTTL from a duration returned by Cache.TTL.
+func NewKeyTTL(key string, ttl time.Duration, policy TTLPolicy) KeyTTL {
+	secs := int64(ttl / time.Second)
+	if ttl < 0 {
+		// sentinels are not scaled to seconds
+		secs = int64(ttl)
+	}
+	return KeyTTL{Key: key, TTLSeconds: secs, Base: policy.Base.String(), Jitter: policy.Jitter.String()}
+}
@@ -1,6 +1,6 @@
 import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
 import { CacheEvent } from '@/types'
-import { Activity, Zap, AlertCircle, Plus, Pencil, Trash2, Clock } from 'lucide-react'
+import { Activity, Zap, AlertCircle, Plus, Pencil, Trash2, Clock, Save, RefreshCw, Lock } from 'lucide-react'
 import { cn } from '@/lib/utils'
 
 interface EventLogProps {
@@ -14,6 +14,10 @@ const eventConfig = {
   add: { icon: Plus, color: 'text-blue-500', bg: 'bg-blue-500/10', label: 'ADDED' },
   update: { icon: Pencil, color: 'text-purple-500', bg: 'bg-purple-500/10', label: 'UPDATED' },
   delete: { icon: Trash2, color: 'text-red-500', bg: 'bg-red-500/10', label: 'DELETED' },
+  write: { icon: Save, color: 'text-blue-500', bg: 'bg-blue-500/10', label: 'CACHE WRITE' },
+  invalidate: { icon: RefreshCw, color: 'text-purple-500', bg: 'bg-purple-500/10', label: 'INVALIDATED' },
+  lock: { icon: Lock, color: 'text-yellow-500', bg: 'bg-yellow-500/10', label: 'LOCK' },
+  expiry: { icon: Clock, color: 'text-gray-500', bg: 'bg-gray-500/10', label: 'EXPIRED' },
 }
 
 export function EventLog({ events }: EventLogProps) {
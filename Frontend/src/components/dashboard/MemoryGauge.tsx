@@ -5,12 +5,12 @@ import { HardDrive, Cpu } from 'lucide-react'
 
 interface MemoryGaugeProps {
   used: number
-  total: number
+  total: number // 0 when there is no memory limit
   itemCount: number
 }
 
 export function MemoryGauge({ used, total, itemCount }: MemoryGaugeProps) {
-  const percentage = (used / total) * 100
+  const percentage = total > 0 ? (used / total) * 100 : 0
   
   const getStatusColor = () => {
     if (percentage < 50) return 'bg-green-500'
@@ -50,7 +50,7 @@ export function MemoryGauge({ used, total, itemCount }: MemoryGaugeProps) {
           <div className="flex justify-between items-end mb-2">
             <span className="text-4xl font-bold font-mono">{percentage.toFixed(1)}%</span>
             <span className="text-sm text-muted-foreground font-mono">
-              {formatBytes(used)} / {formatBytes(total)}
+              {formatBytes(used)} / {total > 0 ? formatBytes(total) : 'no limit'}
             </span>
           </div>
           
@@ -84,7 +84,7 @@ export function MemoryGauge({ used, total, itemCount }: MemoryGaugeProps) {
             </div>
             <div>
               <p className="text-xs text-muted-foreground">Available</p>
-              <p className="text-lg font-semibold font-mono">{formatBytes(total - used)}</p>
+              <p className="text-lg font-semibold font-mono">{total > 0 ? formatBytes(Math.max(total - used, 0)) : '∞'}</p>
             </div>
           </div>
         </div>
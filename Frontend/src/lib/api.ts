@@ -1,3 +1,5 @@
+import { CacheKeyInfo, ServerCacheStats } from '@/types'
+
 // @ts-ignore - Vite environment variables
 // In Docker, use relative path /api (nginx will proxy to backend)
 // In development, use full URL
@@ -73,6 +75,15 @@ class ApiClient {
     return data
   }
 
+  // EventSource can't send headers, so the token goes in the query string
+  streamURL(endpoint: string): string {
+    const url = new URL(`${this.baseURL}${endpoint}`, window.location.origin)
+    if (this.token) {
+      url.searchParams.set('token', this.token)
+    }
+    return url.toString()
+  }
+
   async get<T>(endpoint: string): Promise<ApiResponse<T>> {
     return this.request<T>(endpoint, { method: 'GET' })
   }
@@ -146,3 +157,24 @@ export const productAPI = {
     return response
   },
 }
+
+// Cache API (requires a user listed in ADMIN_USER_IDS, others get a 403)
+export const cacheAPI = {
+  getStats: async () => {
+    const response = await apiClient.get<ServerCacheStats>('/cache/stats')
+    return response
+  },
+
+  getKeys: async (match = '*', limit = 100) => {
+    const response = await apiClient.get<CacheKeyInfo[]>(
+      `/cache/keys?match=${encodeURIComponent(match)}&limit=${limit}`
+    )
+    return response
+  },
+
+  // URL of the Server-Sent Events stream, for an EventSource
+  eventsURL: (types?: string[]) => {
+    const query = types && types.length > 0 ? `?types=${types.join(',')}` : ''
+    return apiClient.streamURL(`/cache/events${query}`)
+  },
+}
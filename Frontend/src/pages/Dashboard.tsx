@@ -8,6 +8,25 @@ import { formatPercentage } from '@/lib/utils'
 import { Zap, AlertCircle, Trash2, Activity, RefreshCw, Server, TestTube } from 'lucide-react'
 import { Button } from '@/components/ui/button'
 import { BtnCache, useClearBtnCache, useBtnCacheStats } from '@/components/ui/btn-cache'
+import { cacheAPI } from '@/lib/api'
+import { useAuthStore } from '@/store/authStore'
+import { CacheEvent, ServerCacheEvent, ServerCacheStats } from '@/types'
+
+// How often the live cache stats are fetched from the backend
+const STATS_POLL_INTERVAL = 5000
+// Events kept in the log, like the local simulation
+const MAX_EVENTS = 50
+
+function toCacheEvent(e: ServerCacheEvent): CacheEvent {
+  const key = e.key ?? ''
+  return {
+    id: String(e.id),
+    type: e.type,
+    productId: key.slice(key.lastIndexOf(':') + 1),
+    productName: e.detail ? `${key} (${e.detail})` : key,
+    timestamp: Date.parse(e.time),
+  }
+}
 
 export default function Dashboard() {
   // Use Zustand selectors to ensure real-time updates
@@ -20,6 +39,12 @@ export default function Dashboard() {
   const fillMemoryForTesting = useCacheStore((state) => state.fillMemoryForTesting)
   const stopFillingMemory = useCacheStore((state) => state.stopFillingMemory)
   const isFillingMemory = useCacheStore((state) => state.isFillingMemory)
+  const token = useAuthStore((state) => state.token)
+  const [serverStats, setServerStats] = useState<ServerCacheStats | null>(null)
+  // Set once the backend refused the stats: only admins may read them
+  const [statsDenied, setStatsDenied] = useState(false)
+  // null until the event stream is connected
+  const [serverEvents, setServerEvents] = useState<CacheEvent[] | null>(null)
   
   const handleFillMemory = async () => {
     try {
@@ -37,6 +62,15 @@ export default function Dashboard() {
   const cacheSize = getCacheSize()
   const products = Array.from(cache.values())
 
+  // Show what the backend reports once it answers, the local simulation
+  // until then (or when the stats can't be fetched)
+  const hits = serverStats ? serverStats.hits : metrics.cacheHits
+  const misses = serverStats ? serverStats.misses : metrics.cacheMisses
+  const totalRequests = serverStats ? hits + misses : metrics.totalRequests
+  const hitRate = serverStats ? serverStats.hit_rate : metrics.hitRate
+  const missRate = serverStats ? (totalRequests > 0 ? misses / totalRequests : 0) : metrics.missRate
+  const evictions = serverStats ? serverStats.evictions : metrics.evictions
+
   useEffect(() => {
     // Load all products from backend on mount
     // This populates availableProductsCache but does NOT add them to cache
@@ -49,6 +83,59 @@ export default function Dashboard() {
     })
   }, [])
 
+  useEffect(() => {
+    setServerEvents(null)
+    // The stream refuses anonymous and non-admin users, don't keep asking
+    if (!token || statsDenied) {
+      return
+    }
+    const source = new EventSource(cacheAPI.eventsURL())
+    source.onopen = () => {
+      setServerEvents((current) => current ?? [])
+    }
+    source.onmessage = (message) => {
+      const event = toCacheEvent(JSON.parse(message.data))
+      setServerEvents((current) => [event, ...(current ?? [])].slice(0, MAX_EVENTS))
+    }
+    source.addEventListener('dropped', (message) => {
+      const { count } = JSON.parse((message as MessageEvent).data)
+      console.warn(`Missed ${count} cache events, the dashboard fell behind`)
+    })
+    return () => source.close()
+  }, [token, statsDenied])
+
+  useEffect(() => {
+    setServerStats(null)
+    setStatsDenied(false)
+    if (!token) {
+      return
+    }
+    let cancelled = false
+    const loadStats = () => {
+      cacheAPI.getStats().then((response) => {
+        if (!cancelled && response.data) {
+          setServerStats(response.data)
+        }
+      }).catch((error) => {
+        if (error?.status === 401 || error?.status === 403) {
+          // Not an admin: asking again won't change the answer
+          clearInterval(interval)
+          if (!cancelled) {
+            setStatsDenied(true)
+          }
+          return
+        }
+        console.error('Failed to load cache stats:', error)
+      })
+    }
+    const interval = setInterval(loadStats, STATS_POLL_INTERVAL)
+    loadStats()
+    return () => {
+      cancelled = true
+      clearInterval(interval)
+    }
+  }, [token])
+
   return (
     <div className="space-y-6">
       {/* Header */}
@@ -58,6 +145,13 @@ export default function Dashboard() {
           <p className="text-muted-foreground mt-1">
             Real-time cache performance monitoring and management
           </p>
+          {!serverStats && (
+            <p className="text-sm text-orange-500 mt-1">
+              {statsDenied || !token
+                ? 'Server cache stats are for admins only: showing the local simulation.'
+                : 'Showing the local simulation until the server cache stats load.'}
+            </p>
+          )}
         </div>
         <div className="flex items-center gap-2">
           <BtnCache 
@@ -112,30 +206,30 @@ export default function Dashboard() {
       <div className="grid gap-4 md:grid-cols-2 lg:grid-cols-4">
         <MetricsCard
           title="Hit Rate"
-          value={formatPercentage(metrics.hitRate)}
-          subtitle={`${metrics.cacheHits} hits out of ${metrics.totalRequests} requests`}
+          value={formatPercentage(hitRate)}
+          subtitle={`${hits} hits out of ${totalRequests} requests`}
           icon={<Zap className="w-6 h-6" />}
           variant="success"
-          trend={metrics.hitRate > 0.7 ? 'up' : metrics.hitRate > 0.3 ? 'neutral' : 'down'}
-          trendValue={metrics.hitRate > 0.7 ? 'Good' : metrics.hitRate > 0.3 ? 'Moderate' : 'Low'}
+          trend={hitRate > 0.7 ? 'up' : hitRate > 0.3 ? 'neutral' : 'down'}
+          trendValue={hitRate > 0.7 ? 'Good' : hitRate > 0.3 ? 'Moderate' : 'Low'}
         />
         <MetricsCard
           title="Miss Rate"
-          value={formatPercentage(metrics.missRate)}
-          subtitle={`${metrics.cacheMisses} misses required DB fetch`}
+          value={formatPercentage(missRate)}
+          subtitle={`${misses} misses required DB fetch`}
           icon={<AlertCircle className="w-6 h-6" />}
           variant="warning"
         />
         <MetricsCard
           title="Total Evictions"
-          value={metrics.evictions}
+          value={evictions}
           subtitle="Items removed due to memory limit"
           icon={<Trash2 className="w-6 h-6" />}
           variant="danger"
         />
         <MetricsCard
           title="Total Requests"
-          value={metrics.totalRequests}
+          value={totalRequests}
           subtitle="Cache lookup operations"
           icon={<Activity className="w-6 h-6" />}
         />
@@ -145,13 +239,13 @@ export default function Dashboard() {
       <div className="grid gap-6 lg:grid-cols-3">
         <div className="lg:col-span-1">
           <MemoryGauge 
-            used={cacheSize} 
-            total={MAX_MEMORY} 
-            itemCount={products.length}
+            used={serverStats ? serverStats.memory.used : cacheSize} 
+            total={serverStats ? serverStats.memory.max : MAX_MEMORY} 
+            itemCount={serverStats ? serverStats.keys : products.length}
           />
         </div>
         <div className="lg:col-span-2">
-          <EventLog events={events} />
+          <EventLog events={serverEvents ?? events} />
         </div>
       </div>
 
@@ -33,7 +33,18 @@ export interface User {
   lastActive?: number
 }
 
-export type CacheEventType = 'hit' | 'miss' | 'eviction' | 'add' | 'update' | 'delete'
+export type CacheEventType =
+  | 'hit'
+  | 'miss'
+  | 'eviction'
+  | 'add'
+  | 'update'
+  | 'delete'
+  // only sent by the backend event stream
+  | 'write'
+  | 'invalidate'
+  | 'lock'
+  | 'expiry'
 
 export interface CacheEvent {
   id: string
@@ -43,3 +54,60 @@ export interface CacheEvent {
   timestamp: number
 }
 
+
+// Returned by GET /api/cache/stats. Hits and misses are counted by the
+// backend instance; keys and memory are reported by Redis itself.
+export interface ServerCacheStats {
+  hits: number
+  misses: number
+  hit_rate: number
+  evictions: number
+  keys: number
+  memory: {
+    used: number
+    max: number // 0 when Redis has no maxmemory
+    used_ratio: number
+    policy?: string
+  }
+  server: {
+    backend: string
+    keys: number
+    used_memory: number
+    max_memory: number
+    eviction_policy?: string
+    keyspace_hits: number
+    keyspace_misses: number
+    evicted_keys: number
+    expired_keys: number
+  }
+  entities: Record<string, {
+    reads: {
+      l1: number
+      l2: number
+      db: number
+      stale: number
+      coalesced: number
+      hits: number
+      misses: number
+      hit_rate: number
+    }
+  }>
+}
+
+// Returned by GET /api/cache/keys
+export interface CacheKeyInfo {
+  key: string
+  type: string
+  ttl_seconds: number // -1 when the key has no expiry
+  size: number // bytes, as reported by MEMORY USAGE
+}
+
+// Sent by the backend on /api/cache/events
+export interface ServerCacheEvent {
+  id: number
+  type: 'hit' | 'miss' | 'write' | 'invalidate' | 'lock' | 'eviction' | 'expiry' | 'delete'
+  entity?: string
+  key?: string
+  detail?: string // e.g. the tier of a hit, or what a write was
+  time: string
+}